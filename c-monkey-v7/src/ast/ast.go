@@ -3,12 +3,29 @@ package ast
 import (
 	"Compiler/c-monkey-v7/src/token"
 	"bytes"
+	"math/big"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // Position of the first char of the node in the source
+}
+
+// Position errors raised by the node should point at. The operator (or the [ of an index) is more useful than the
+// start of the left operand. Both the compiler and the evaluator use it, so that their errors point at the same place
+func ErrorPosition(node Node) token.Position {
+	switch node := node.(type) {
+	case *InfixExpression:
+		return node.Token.Pos
+	case *IndexExpression:
+		return node.Token.Pos
+	case *AssignExpression:
+		return node.Token.Pos
+	default:
+		return node.Pos()
+	}
 }
 
 type Statement interface {
@@ -23,6 +40,7 @@ type Expression interface {
 
 type Program struct { // Program is the root node of a program
 	Statements []Statement
+	Comments   []token.Comment // Comments after the last statement, which have no token of their own to go on
 }
 
 func (p *Program) String() string {
@@ -44,6 +62,9 @@ func (i *Identifier) expressionNode() {}
 func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
+func (i *Identifier) Pos() token.Position {
+	return i.Token.Pos
+}
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -58,6 +79,9 @@ func (ls *LetStatement) statementNode() {}
 func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
+func (ls *LetStatement) Pos() token.Position {
+	return ls.Token.Pos
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -82,6 +106,9 @@ func (rs *ReturnStatement) statementNode() {}
 func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
+func (rs *ReturnStatement) Pos() token.Position {
+	return rs.Token.Pos
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -94,6 +121,161 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+type WhileStatement struct {
+	Token     token.Token // token.WHILE
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+func (ws *WhileStatement) TokenLiteral() string {
+	return ws.Token.Literal
+}
+func (ws *WhileStatement) Pos() token.Position {
+	return ws.Token.Pos
+}
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+type ForStatement struct {
+	Token    token.Token   // token.FOR
+	Names    []*Identifier // One name binds the element (the key for hashes), two bind the index or key and the value
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+func (fs *ForStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+func (fs *ForStatement) Pos() token.Position {
+	return fs.Token.Pos
+}
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	names := []string{}
+	for _, n := range fs.Names {
+		names = append(names, n.String())
+	}
+
+	out.WriteString("for(")
+	out.WriteString(strings.Join(names, ", "))
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // token.BREAK
+}
+
+func (bs *BreakStatement) statementNode() {}
+func (bs *BreakStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+func (bs *BreakStatement) Pos() token.Position {
+	return bs.Token.Pos
+}
+func (bs *BreakStatement) String() string {
+	return bs.TokenLiteral() + ";"
+}
+
+type ContinueStatement struct {
+	Token token.Token // token.CONTINUE
+}
+
+func (cs *ContinueStatement) statementNode() {}
+func (cs *ContinueStatement) TokenLiteral() string {
+	return cs.Token.Literal
+}
+func (cs *ContinueStatement) Pos() token.Position {
+	return cs.Token.Pos
+}
+func (cs *ContinueStatement) String() string {
+	return cs.TokenLiteral() + ";"
+}
+
+type ThrowStatement struct {
+	Token token.Token // token.THROW
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+func (ts *ThrowStatement) Pos() token.Position {
+	return ts.Token.Pos
+}
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+// At least one of Catch and Finally is set. CatchName is the name the caught value is bound to
+type TryStatement struct {
+	Token     token.Token // token.TRY
+	Body      *BlockStatement
+	CatchName *Identifier
+	Catch     *BlockStatement
+	Finally   *BlockStatement
+}
+
+func (ts *TryStatement) statementNode() {}
+func (ts *TryStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+func (ts *TryStatement) Pos() token.Position {
+	return ts.Token.Pos
+}
+func (ts *TryStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(ts.Body.String())
+	if ts.Catch != nil {
+		out.WriteString(" catch(")
+		out.WriteString(ts.CatchName.String())
+		out.WriteString(") ")
+		out.WriteString(ts.Catch.String())
+	}
+	if ts.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(ts.Finally.String())
+	}
+
+	return out.String()
+}
+
+// Stands in for a statement that failed to parse, so that the statements around it are still usable. The broken
+// region runs from Token up to End
+type BadStatement struct {
+	Token token.Token // The first token of the statement
+	End   token.Position
+}
+
+func (bs *BadStatement) statementNode() {}
+func (bs *BadStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+func (bs *BadStatement) Pos() token.Position {
+	return bs.Token.Pos
+}
+func (bs *BadStatement) String() string {
+	return "<bad statement>"
+}
+
 type ExpressionStatement struct { // We have expression statements so we can add this to Program.Statements
 	Token      token.Token // first token of the expression
 	Expression Expression
@@ -103,6 +285,9 @@ func (es *ExpressionStatement) statementNode() {}
 func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
+func (es *ExpressionStatement) Pos() token.Position {
+	return es.Token.Pos
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -111,6 +296,22 @@ func (es *ExpressionStatement) String() string {
 	return ""
 }
 
+// Stands in for an expression that failed to parse, ie, a missing operand, so that the statement around it is kept
+type BadExpression struct {
+	Token token.Token // Where the broken expression starts, or its operator for calls, index and assign expressions
+}
+
+func (be *BadExpression) expressionNode() {}
+func (be *BadExpression) TokenLiteral() string {
+	return be.Token.Literal
+}
+func (be *BadExpression) Pos() token.Position {
+	return be.Token.Pos
+}
+func (be *BadExpression) String() string {
+	return "<bad expression>"
+}
+
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
@@ -120,10 +321,46 @@ func (il *IntegerLiteral) expressionNode() {}
 func (il *IntegerLiteral) TokenLiteral() string {
 	return il.Token.Literal
 }
+func (il *IntegerLiteral) Pos() token.Position {
+	return il.Token.Pos
+}
 func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
+// An integer literal too large for an int64
+type BigIntegerLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (bl *BigIntegerLiteral) expressionNode() {}
+func (bl *BigIntegerLiteral) TokenLiteral() string {
+	return bl.Token.Literal
+}
+func (bl *BigIntegerLiteral) Pos() token.Position {
+	return bl.Token.Pos
+}
+func (bl *BigIntegerLiteral) String() string {
+	return bl.Token.Literal
+}
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+func (fl *FloatLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+func (fl *FloatLiteral) Pos() token.Position {
+	return fl.Token.Pos
+}
+func (fl *FloatLiteral) String() string {
+	return fl.Token.Literal
+}
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -134,6 +371,9 @@ func (pe *PrefixExpression) expressionNode() {}
 func (pe *PrefixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
+func (pe *PrefixExpression) Pos() token.Position {
+	return pe.Token.Pos
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -156,6 +396,12 @@ func (ie *InfixExpression) expressionNode() {}
 func (ie *InfixExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil { // Starts at the left operand, not at the operator
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -168,6 +414,35 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+type AssignExpression struct {
+	Token    token.Token // The assignment operator, =, +=, -=, *= or /=
+	Target   Expression  // *Identifier or *IndexExpression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+func (ae *AssignExpression) TokenLiteral() string {
+	return ae.Token.Literal
+}
+func (ae *AssignExpression) Pos() token.Position {
+	if ae.Target != nil {
+		return ae.Target.Pos()
+	}
+	return ae.Token.Pos
+}
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -177,6 +452,9 @@ func (b *Boolean) expressionNode() {}
 func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Pos
+}
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
@@ -194,6 +472,9 @@ func (ie *IfExpression) expressionNode() {}
 func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
+func (ie *IfExpression) Pos() token.Position {
+	return ie.Token.Pos
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -219,6 +500,9 @@ func (bs *BlockStatement) statementNode() {}
 func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
+func (bs *BlockStatement) Pos() token.Position {
+	return bs.Token.Pos
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -233,16 +517,23 @@ type FunctionLiteral struct {
 	Token      token.Token // token.FN
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Name       string // Name of the let binding the function is assigned to, if any. Lets the function refer to itself
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
 func (fl *FunctionLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
+func (fl *FunctionLiteral) Pos() token.Position {
+	return fl.Token.Pos
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("fn")
+	if fl.Name != "" {
+		out.WriteString("<" + fl.Name + ">")
+	}
 	params := []string{}
 	for _, p := range fl.Parameters {
 		params = append(params, p.String())
@@ -265,6 +556,12 @@ func (ce *CallExpression) expressionNode() {}
 func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil { // Starts at the callee, not at the (
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -288,6 +585,12 @@ func (p *Program) TokenLiteral() string {
 		return ""
 	}
 }
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
 
 type StringLiteral struct {
 	Token token.Token
@@ -298,6 +601,9 @@ func (sl *StringLiteral) expressionNode() {}
 func (sl *StringLiteral) TokenLiteral() string {
 	return sl.Token.Literal
 }
+func (sl *StringLiteral) Pos() token.Position {
+	return sl.Token.Pos
+}
 func (sl *StringLiteral) String() string {
 	return sl.Token.Literal
 }
@@ -311,6 +617,9 @@ func (al *ArrayLiteral) expressionNode() {}
 func (al *ArrayLiteral) TokenLiteral() string {
 	return al.Token.Literal
 }
+func (al *ArrayLiteral) Pos() token.Position {
+	return al.Token.Pos
+}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -336,6 +645,12 @@ func (ie *IndexExpression) expressionNode() {}
 func (ie *IndexExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil { // Starts at the left operand, not at the operator
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -357,6 +672,9 @@ func (hl *HashLiteral) expressionNode() {}
 func (hl *HashLiteral) TokenLiteral() string {
 	return hl.Token.Literal
 }
+func (hl *HashLiteral) Pos() token.Position {
+	return hl.Token.Pos
+}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -26,3 +26,24 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestLoopStatementsString(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&WhileStatement{
+				Token:     token.Token{Type: token.WHILE, Literal: "while"},
+				Condition: &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&BreakStatement{Token: token.Token{Type: token.BREAK, Literal: "break"}},
+						&ContinueStatement{Token: token.Token{Type: token.CONTINUE, Literal: "continue"}},
+					},
+				},
+			},
+		},
+	}
+
+	if program.String() != "whilex break;continue;" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
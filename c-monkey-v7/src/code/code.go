@@ -6,6 +6,10 @@ import (
 	"fmt"
 )
 
+// Version of the opcode set. Bump it whenever an opcode is added, removed or changes its operands, so that
+// bytecode compiled for another set of opcodes is rejected instead of misread
+const Version = 10
+
 type Instructions []byte // Instructions can be any length of bytes
 type Opcode byte         // Opcodes are always a single byte
 
@@ -37,6 +41,43 @@ const ( // Each Opcode is going to have a readable name instead of some arbitrar
 	OpReturn
 	OpGetLocal
 	OpSetLocal
+	OpClosure // Wrap a constant CompiledFunction along with its free variables into a Closure
+	OpGetFree
+	OpGetBuiltin
+	OpCurrentClosure // Push the closure of the current frame, used by functions that call themselves
+	OpSetFree        // Assign to a variable the current closure captured
+	OpIter           // Replace the array, string or hash on top of the stack with an iterator over it
+	OpIterNext       // Advance the iterator on top of the stack and push what it yields, or pop it and jump once it is done
+	OpSetIndex       // Store a value into an array or hash, ie, collection[index] = value
+
+	// Short circuit jumps for && and ||. If the value on top of the stack decides the result (not truthy for &&,
+	// truthy for ||) jump and keep it as the result, else pop it so the right operand can take its place
+	OpJumpNotTruthyOrPop
+	OpJumpTruthyOrPop
+
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpBitNot // Unary ~
+
+	OpThrow // Raise the value on top of the stack, see HandlerTable for where it is caught
+
+	OpSetLocalKeep // Like OpSetLocal but leaves the value on the stack, emitted by the peephole optimizer
+
+	OpCompoundSetIndex // Like OpSetIndex, but the value is first combined with the current element, ie, collection[index] += value
+
+	// Push the cell of a local, or of a free variable of the current closure, for OpClosure to capture. A local is
+	// moved into a cell the first time it is captured, see object.Cell
+	OpCaptureLocal
+	OpCaptureFree
+
+	// < and <=. Not compiled as > and >= with swapped operands, so the left operand is still evaluated first
+	OpLessThan
+	OpLessThanOrEqual
 )
 
 type Definition struct { // To keep track of how many operands an opcode has and make it more readable
@@ -74,6 +115,43 @@ var definitions = map[Opcode]*Definition{
 	OpReturn:             {"OpReturn", []int{}},
 	OpGetLocal:           {"OpGetLocal", []int{1}},
 	OpSetLocal:           {"OpSetLocal", []int{1}},
+	// First operand is the constant index of the function (2 bytes), second is the number of free variables on the stack (1 byte)
+	OpClosure: {"OpClosure", []int{2, 1}},
+	OpGetFree: {"OpGetFree", []int{1}},
+	// Operand is the index of the builtin function in object.Builtins
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpIter:           {"OpIter", []int{}},
+	// First operand is where to jump once the iterator is exhausted, second is how many values to push (1 for the
+	// element, 2 for the key and value)
+	OpIterNext: {"OpIterNext", []int{2, 1}},
+	OpSetIndex: {"OpSetIndex", []int{}},
+
+	OpJumpNotTruthyOrPop: {"OpJumpNotTruthyOrPop", []int{2}},
+	OpJumpTruthyOrPop:    {"OpJumpTruthyOrPop", []int{2}},
+
+	OpMod:        {"OpMod", []int{}},
+	OpPow:        {"OpPow", []int{}},
+	OpBitAnd:     {"OpBitAnd", []int{}},
+	OpBitOr:      {"OpBitOr", []int{}},
+	OpBitXor:     {"OpBitXor", []int{}},
+	OpShiftLeft:  {"OpShiftLeft", []int{}},
+	OpShiftRight: {"OpShiftRight", []int{}},
+	OpBitNot:     {"OpBitNot", []int{}},
+
+	OpThrow: {"OpThrow", []int{}},
+
+	OpSetLocalKeep: {"OpSetLocalKeep", []int{1}},
+
+	// Operand is the binary operator of the compound assignment, applied to the current element and the value
+	OpCompoundSetIndex: {"OpCompoundSetIndex", []int{1}},
+
+	OpCaptureLocal: {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:  {"OpCaptureFree", []int{1}},
+
+	OpLessThan:        {"OpLessThan", []int{}},
+	OpLessThanOrEqual: {"OpLessThanOrEqual", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -119,15 +197,13 @@ func (ins Instructions) String() string { // Purely a method that is used for te
 
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(ins[i])
+		def, operands, width, err := ReadInstruction(ins, i)
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+		} else {
+			fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
 		}
-
-		operands, read := ReadOperands(def, ins[i+1:])
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
-		i += 1 + read
+		i += width // Always at least 1, so malformed input can't stall the loop
 	}
 
 	return out.String()
@@ -145,11 +221,36 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
 }
 
+// Decodes the instruction starting at ins[offset] and returns its width in bytes along with it. Unlike ReadOperands
+// this checks that the opcode is defined and all of its operands are present, so it is safe on malformed bytecode.
+// On an unknown opcode the width is 1, on a truncated instruction it is whatever is left of ins
+func ReadInstruction(ins Instructions, offset int) (*Definition, []int, int, error) {
+	def, err := Lookup(ins[offset])
+	if err != nil {
+		return nil, nil, 1, err
+	}
+
+	operandWidth := 0
+	for _, w := range def.OperandWidths {
+		operandWidth += w
+	}
+
+	remaining := len(ins) - offset - 1
+	if remaining < operandWidth {
+		return def, nil, 1 + remaining, fmt.Errorf("truncated %s, want %d operand bytes, got %d", def.Name, operandWidth, remaining)
+	}
+
+	operands, read := ReadOperands(def, ins[offset+1:])
+	return def, operands, 1 + read, nil
+}
+
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) { // Helper method used to read the operands of an instruction during decoding
 	operands := make([]int, len(def.OperandWidths))
 	offset := 0
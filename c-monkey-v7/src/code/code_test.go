@@ -15,6 +15,7 @@ func TestMake(t *testing.T) {
 		*/
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
 	}
 
 	for _, tt := range tests {
@@ -38,12 +39,14 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
 	}
 
 	expected := `0000 OpAdd
 0001 OpGetLocal 1
 0003 OpConstant 2
 0006 OpConstant 65535
+0009 OpClosure 65535 255
 `
 
 	concatted := Instructions{} // Once again, flattening [][]byte to []byte
@@ -56,6 +59,24 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringMalformed(t *testing.T) {
+	concatted := Instructions{}
+	concatted = append(concatted, Make(OpAdd)...)
+	concatted = append(concatted, 255) // Unknown opcode
+	concatted = append(concatted, Make(OpPop)...)
+	concatted = append(concatted, Make(OpConstant, 513)[:2]...) // Missing the last operand byte
+
+	expected := `0000 OpAdd
+0001 ERROR: opcode 255 undefined
+0002 OpPop
+0003 ERROR: truncated OpConstant, want 2 operand bytes, got 1
+`
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted. \nwant=%q, \ngot=%q", expected, concatted.String())
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -64,6 +85,7 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
 	}
 
 	for _, tt := range tests {
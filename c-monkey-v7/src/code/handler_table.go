@@ -0,0 +1,24 @@
+package code
+
+// One protected range of a function. An error raised, or a value thrown, by an instruction in [Start, End) resumes
+// execution at Handler with the stack cut back to StackDepth values above the locals and the error pushed on top
+type Handler struct {
+	Start      int
+	End        int
+	Handler    int
+	StackDepth int
+}
+
+// The exception handlers of a function. Nested ranges are listed before the ranges enclosing them, so the first
+// match is the innermost handler
+type HandlerTable []Handler
+
+// The handler for the instruction at offset, which may also point into the operands
+func (ht HandlerTable) HandlerFor(offset int) (Handler, bool) {
+	for _, h := range ht {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
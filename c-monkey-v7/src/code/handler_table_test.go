@@ -0,0 +1,29 @@
+package code
+
+import "testing"
+
+func TestHandlerTableHandlerFor(t *testing.T) {
+	inner := Handler{Start: 4, End: 8, Handler: 20, StackDepth: 1}
+	outer := Handler{Start: 0, End: 12, Handler: 30, StackDepth: 0}
+	table := HandlerTable{inner, outer}
+
+	tests := []struct {
+		offset   int
+		expected Handler
+		ok       bool
+	}{
+		{0, outer, true},
+		{4, inner, true},
+		{7, inner, true},
+		{8, outer, true},
+		{11, outer, true},
+		{12, Handler{}, false},
+	}
+
+	for _, tt := range tests {
+		h, ok := table.HandlerFor(tt.offset)
+		if ok != tt.ok || h != tt.expected {
+			t.Errorf("wrong handler for offset %d. want=%+v (%t), got=%+v (%t)", tt.offset, tt.expected, tt.ok, h, ok)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package code
+
+import (
+	"Compiler/c-monkey-v7/src/token"
+	"sort"
+)
+
+type SourceMapEntry struct {
+	Offset int            // Offset of the first instruction the position applies to
+	Pos    token.Position // Position of the source that was compiled into the instruction
+}
+
+// Maps instruction offsets back to the source they were compiled from. Entries are sorted by Offset and an entry
+// covers every instruction up to the next entry, so consecutive instructions from the same source share one entry
+type SourceMap []SourceMapEntry
+
+// Position of the source that produced the instruction at offset. The offset may also point into the operands
+func (sm SourceMap) PositionFor(offset int) (token.Position, bool) {
+	// Index of the first entry that starts after offset, the one before it is the one covering offset
+	i := sort.Search(len(sm), func(i int) bool { return sm[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}, false
+	}
+
+	return sm[i-1].Pos, true
+}
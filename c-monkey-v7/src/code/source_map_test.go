@@ -0,0 +1,49 @@
+package code
+
+import (
+	"Compiler/c-monkey-v7/src/token"
+	"testing"
+)
+
+func TestSourceMapPositionFor(t *testing.T) {
+	first := token.Position{Line: 1, Column: 1}
+	second := token.Position{Line: 1, Column: 5}
+	third := token.Position{Line: 2, Column: 3}
+
+	sourceMap := SourceMap{
+		{Offset: 0, Pos: first},
+		{Offset: 3, Pos: second},
+		{Offset: 7, Pos: third},
+	}
+
+	tests := []struct {
+		offset      int
+		expectedPos token.Position
+	}{
+		{0, first},
+		{2, first},
+		{3, second},
+		{6, second},
+		{7, third},
+		{100, third},
+	}
+
+	for _, tt := range tests {
+		pos, ok := sourceMap.PositionFor(tt.offset)
+		if !ok {
+			t.Errorf("no position for offset %d", tt.offset)
+			continue
+		}
+		if pos != tt.expectedPos {
+			t.Errorf("wrong position for offset %d. want=%+v, got=%+v", tt.offset, tt.expectedPos, pos)
+		}
+	}
+
+	if _, ok := (SourceMap{{Offset: 2, Pos: first}}).PositionFor(1); ok {
+		t.Errorf("expected no position before the first entry")
+	}
+
+	if _, ok := (SourceMap{}).PositionFor(0); ok {
+		t.Errorf("expected no position in an empty source map")
+	}
+}
@@ -3,23 +3,34 @@ package compiler
 import (
 	"Compiler/c-monkey-v7/src/ast"
 	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/diagnostic"
 	"Compiler/c-monkey-v7/src/object"
-	"fmt"
+	"Compiler/c-monkey-v7/src/token"
 	"sort"
 )
 
 type Compiler struct {
-	constants []object.Object // Internal constant pool
+	constants       []object.Object // Internal constant pool
+	constantIndexes map[string]int  // Pool index of every constant by its value, see constantKey
 
 	symbolTable *SymbolTable // Identifier store
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	position token.Position // Position of the node currently being compiled, recorded in the source map on every emit
+
+	// Fold constants before emitting, see foldProgram, and share equal constants in the pool
+	optimize bool
+	peephole bool // Clean up the instructions of every finished scope, see optimizeInstructions
 }
 
 type Bytecode struct { // Both are exportable fields since they start with capitalized letters. This gets passed into the VM
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap    // Source positions of Instructions. Functions carry their own in the constant pool
+	GlobalNames  []string          // Names of the global slots, for the disassembler
+	Handlers     code.HandlerTable // Exception handlers of the main program
 }
 
 type EmittedInstruction struct {
@@ -31,6 +42,35 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	sourceMap           code.SourceMap
+
+	hoisted  map[*ast.LetStatement]Symbol // Function bindings defined up front so that functions can reference each other
+	loops    []*loopContext               // Enclosing loops, innermost last. Kept per scope since break can't cross a function
+	tries    []*tryContext                // Enclosing trys, innermost last
+	handlers code.HandlerTable            // Exception handlers of the instructions, see compileTryStatement
+
+	// Number of values on the stack above the locals when the next instruction runs, so that an exception handler
+	// knows how far to cut the stack back. Only tracked along the path that falls through, see stackEffect
+	stackDepth int
+}
+
+// Where break and continue inside a loop jump to. The end of the loop is not known while its body is compiled,
+// so the break jumps are emitted with a placeholder and patched once it is
+type loopContext struct {
+	start  int   // Offset of the condition, the target of continue
+	breaks []int // Offsets of the OpJump instructions emitted for break
+
+	iterator bool // A for-in loop, which keeps its iterator on top of the stack while the body runs
+	depth    int  // Stack depth in the body, anything above it is dropped before jumping out of it
+	tries    int  // Number of trys entered before the loop, the ones after it have to be left by break and continue
+}
+
+// A try whose body (or catch block) is being compiled. Jumping out of it, with break, continue or return, runs a copy
+// of the finally block first. That copy is outside the try, so the protected range is split around it
+type tryContext struct {
+	finally *ast.BlockStatement // nil if the try has none
+	start   int                 // Start of the protected range currently open
+	ranges  [][2]int            // Protected ranges closed so far, [start, end)
 }
 
 func New() *Compiler {
@@ -38,28 +78,52 @@ func New() *Compiler {
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
+		hoisted:             make(map[*ast.LetStatement]Symbol),
+	}
+
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins { // Builtins live in the outermost table so they resolve from any scope
+		symbolTable.DefineBuiltin(i, v.Name)
 	}
 
 	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: NewSymbolTable(),
-		scopes:      []CompilationScope{mainScope},
-		scopeIndex:  0,
+		constants:       []object.Object{},
+		constantIndexes: make(map[string]int),
+		symbolTable:     symbolTable,
+		scopes:          []CompilationScope{mainScope},
+		scopeIndex:      0,
+		optimize:        true,
+		peephole:        true,
 	}
 }
 
+// Compiles the code as written, ie, to look at the bytecode of an operator without its operands being folded away
+func (c *Compiler) DisableOptimizations() {
+	c.optimize = false
+	c.peephole = false
+}
+
+// Leaves out only the peephole pass, ie, to measure what it gains on top of folding and interning
+func (c *Compiler) DisablePeephole() {
+	c.peephole = false
+}
+
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	defer c.trackPosition(node)()
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		if c.optimize {
+			node = foldProgram(node)
+		}
+
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
 
 	case *ast.ExpressionStatement:
@@ -70,25 +134,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpPop)
 
 	case *ast.InfixExpression:
-		// if < or <= reorder the execution of stack push for operands, ie, right first and then left
-		if node.Operator == "<" || node.Operator == "<=" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-
-			if node.Operator == "<" {
-				c.emit(code.OpGreaterThan)
-			} else {
-				c.emit(code.OpGreaterThanOrEqual)
-			}
-			return nil
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
 		}
+
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
@@ -108,22 +157,47 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case "**":
+			c.emit(code.OpPow)
+		case "&":
+			c.emit(code.OpBitAnd)
+		case "|":
+			c.emit(code.OpBitOr)
+		case "^":
+			c.emit(code.OpBitXor)
+		case "<<":
+			c.emit(code.OpShiftLeft)
+		case ">>":
+			c.emit(code.OpShiftRight)
 		case ">":
 			c.emit(code.OpGreaterThan)
 		case ">=":
 			c.emit(code.OpGreaterThanOrEqual)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return diagnostic.Errorf(diagnostic.UnknownOperator, diagnostic.TokenSpan(node.Token), "unknown operator %s", node.Operator)
 		}
 
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
 
+	case *ast.BigIntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(object.NewInteger(node.Value)))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -146,8 +220,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpBang)
 		case "-":
 			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return diagnostic.Errorf(diagnostic.UnknownOperator, diagnostic.TokenSpan(node.Token), "unknown operator %s", node.Operator)
 		}
 
 	case *ast.IfExpression:
@@ -165,6 +241,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		if c.lastInstructionIs(code.OpPop) { // We remove the last pop because Block expressions always produce an OpPop which is redundant and we need to keep the final value that the block evaluates to
 			c.removeLastPop()
+		} else { // Empty, or ends in a statement that leaves nothing on the stack (let, while, break...), so the block is null
+			c.emit(code.OpNull)
 		}
 
 		// Jump location that is always executed to skip over the alternative
@@ -174,6 +252,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		afterConsequencePos := len(c.currentInstructions())
 		// Going back and changing the operand, ie, the position where we jump to, instead of 9999
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		c.setStackDepth(c.stackDepth() - 1) // The alternative starts from where the condition was popped, not after the consequence
 
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
@@ -185,41 +264,150 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
 			}
 		}
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
+	case *ast.WhileStatement:
+		loopStart := len(c.currentInstructions())
+
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		exitJumpPos := c.emit(code.OpJumpNotTruthy, 9999) // Leave the loop once the condition is false
+
+		loop := c.enterLoop(loopStart)
+		err = c.Compile(node.Body) // The body is only statements, so it leaves the stack as it found it
+		if err != nil {
+			return err
+		}
+		c.leaveLoop()
+
+		c.emit(code.OpJump, loopStart) // Back to the condition
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(exitJumpPos, afterLoopPos)
+		for _, pos := range loop.breaks {
+			c.changeOperand(pos, afterLoopPos)
+		}
+
+	case *ast.ForStatement:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpIter) // The iterator stays on the stack for as long as the loop runs
+
+		loopStart := len(c.currentInstructions())
+		iterNextPos := c.emit(code.OpIterNext, 9999, len(node.Names))
+
+		// The names are bound like let statements, so they are one binding for the whole loop that the closures made
+		// in the body share. Their values are pushed in order, so the last one is on top
+		symbols := []Symbol{}
+		for _, name := range node.Names {
+			symbols = append(symbols, c.symbolTable.Define(name.Value))
+		}
+		for i := len(symbols) - 1; i >= 0; i-- {
+			c.setSymbol(symbols[i])
+		}
+
+		loop := c.enterLoop(loopStart)
+		loop.iterator = true
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.leaveLoop()
+
+		c.emit(code.OpJump, loopStart)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(iterNextPos, afterLoopPos, len(node.Names))
+		for _, pos := range loop.breaks {
+			c.changeOperand(pos, afterLoopPos)
+		}
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+
+	case *ast.BadStatement:
+		return diagnostic.Errorf(diagnostic.SyntaxError, diagnostic.TokenSpan(node.Token), "cannot compile a statement that failed to parse")
+
+	case *ast.BadExpression:
+		return diagnostic.Errorf(diagnostic.SyntaxError, diagnostic.TokenSpan(node.Token), "cannot compile an expression that failed to parse")
+
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return diagnostic.Errorf(diagnostic.OutsideLoop, diagnostic.TokenSpan(node.Token), "break outside of a loop")
+		}
+
+		err := c.compileFinallyBlocks(loop.tries)
+		if err != nil {
+			return err
+		}
+
+		depth := loop.depth
+		if loop.iterator { // OpIterNext only drops the iterator when it runs out, so leaving early has to do it here
+			depth--
+		}
+		c.dropStack(depth)
+		pos := c.emit(code.OpJump, 9999) // Patched to the end of the loop once it is compiled
+		loop.breaks = append(loop.breaks, pos)
+		c.resumeTries(loop.tries)
+
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return diagnostic.Errorf(diagnostic.OutsideLoop, diagnostic.TokenSpan(node.Token), "continue outside of a loop")
+		}
+
+		err := c.compileFinallyBlocks(loop.tries)
+		if err != nil {
+			return err
+		}
+
+		c.dropStack(loop.depth)
+		c.emit(code.OpJump, loop.start)
+		c.resumeTries(loop.tries)
+
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
+		}
+
+	case *ast.LetStatement:
+		if symbol, ok := c.scopes[c.scopeIndex].hoisted[node]; ok {
+			// The name is already defined, so the function can be referenced by the functions compiled before it
+			err := c.compileFunctionLiteral(node.Value.(*ast.FunctionLiteral))
 			if err != nil {
 				return err
 			}
+			c.setSymbol(symbol)
+			return nil
 		}
 
-	case *ast.LetStatement:
 		err := c.Compile(node.Value) // Evaluate RHS and put it on the stack
 		if err != nil {
 			return err
 		}
 		symbol := c.symbolTable.Define(node.Name.Value)
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.setSymbol(symbol)
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value) // Compile time error instead of runtime
-		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpGetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpGetLocal, symbol.Index)
+			// Compile time error instead of runtime
+			return diagnostic.Errorf(diagnostic.UndefinedVariable, diagnostic.TokenSpan(node.Token), "undefined variable %s", node.Value)
 		}
+		c.loadSymbol(symbol)
 
 	case *ast.ArrayLiteral:
 		for _, e := range node.Elements {
@@ -267,36 +455,33 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpIndex)
 
 	case *ast.FunctionLiteral:
-		c.enterScope() // Enter new scope
-
-		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
+		err := c.compileFunctionLiteral(node)
+		if err != nil {
+			return err
 		}
 
-		err := c.Compile(node.Body) // Compile function body
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) { // The last value from  a function should never be popped, only returned
-			c.replaceLastPopWithReturn()
-		}
-		if !c.lastInstructionIs(code.OpReturnValue) { // Basically, this happens only when the function block was empty
-			c.emit(code.OpReturn)
+		err = c.compileFinallyBlocks(0)
+		if err != nil {
+			return err
 		}
+		c.emit(code.OpReturnValue)
+		c.resumeTries(0)
 
-		numLocals := c.symbolTable.numDefinitions // Number of variables in the local scope of the function
-
-		instructions := c.leaveScope() // Pop function scope
-		compiledFn := &object.CompiledFunction{Instructions: instructions, NumLocals: numLocals, NumParameters: len(node.Parameters)}
-		c.emit(code.OpConstant, c.addConstant(compiledFn))
-
-	case *ast.ReturnStatement:
-		err := c.Compile(node.ReturnValue)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		c.emit(code.OpReturnValue)
+		c.emit(code.OpThrow)
+
+	case *ast.TryStatement:
+		return c.compileTryStatement(node)
 
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
@@ -316,10 +501,333 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// Compiles the function in its own scope and emits the OpClosure that creates it
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	defer c.trackPosition(node)()
+
+	c.enterScope() // Enter new scope
+
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	err := c.Compile(node.Body) // Compile function body
+	if err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) { // The last value from  a function should never be popped, only returned
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) { // Basically, this happens only when the function block was empty
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions // Number of variables in the local scope of the function
+	localNames := c.symbolTable.SlotNames()
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	handlers := c.scopes[c.scopeIndex].handlers
+
+	instructions := c.leaveScope() // Pop function scope
+	if c.peephole {
+		instructions, sourceMap, handlers = optimizeInstructions(instructions, sourceMap, handlers)
+	}
+
+	// Push the cells of the captured variables onto the stack so that OpClosure can pick them up. We are back in the
+	// enclosing scope now, so each free symbol is captured the way the enclosing scope sees it (local, or free again in
+	// case of deeper nesting)
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		SourceMap:     sourceMap,
+		Name:          node.Name,
+		LocalNames:    localNames,
+		FreeNames:     symbolNames(freeSymbols),
+		Handlers:      handlers,
+	}
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	return nil
+}
+
+/*
+A try compiles to its body followed by the handlers, with the finally block copied onto every way out:
+
+	body               protected, handled by catch (or by finally if there is no catch)
+	finally            copy for when the body completes
+	OpJump end
+	catch:             the error is on top of the stack
+	OpSetGlobal e      or OpSetLocal
+	catch block        protected, handled by finally
+	finally            copy for when the catch block completes
+	OpJump end
+	finally:           the error is on top of the stack
+	finally block
+	OpThrow            the error is still on top, so it carries on to the next handler
+	end:
+*/
+func (c *Compiler) compileTryStatement(node *ast.TryStatement) error {
+	depth := c.stackDepth()
+	jumps := []int{}
+
+	try := c.enterTry(node.Finally)
+	err := c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+	c.leaveTry()
+
+	if node.Finally != nil {
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+	}
+	jumps = append(jumps, c.emit(code.OpJump, 9999))
+
+	if node.Catch != nil {
+		c.addHandlers(try, depth)
+		c.setStackDepth(depth + 1)
+
+		symbol := c.symbolTable.Define(node.CatchName.Value)
+		c.setSymbol(symbol)
+
+		try = c.enterTry(node.Finally)
+		err := c.Compile(node.Catch)
+		if err != nil {
+			return err
+		}
+		c.leaveTry()
+
+		if node.Finally != nil {
+			err := c.Compile(node.Finally)
+			if err != nil {
+				return err
+			}
+		}
+		jumps = append(jumps, c.emit(code.OpJump, 9999))
+	}
+
+	if node.Finally != nil {
+		c.addHandlers(try, depth)
+		c.setStackDepth(depth + 1)
+
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	end := len(c.currentInstructions())
+	for _, pos := range jumps {
+		c.changeOperand(pos, end)
+	}
+	c.setStackDepth(depth)
+
+	return nil
+}
+
+// Statements leave the stack as they found it. Setting the depth back after each one also covers the code after a
+// break, continue, return or throw, which is never reached
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	depth := c.stackDepth()
+
+	for i, s := range statements {
+		c.hoistFunctionNames(statements[i:])
+
+		err := c.Compile(s)
+		if err != nil {
+			return err
+		}
+		c.setStackDepth(depth)
+	}
+
+	return nil
+}
+
+// How many values an instruction pushes minus how many it pops. Jumps count the path that falls through, ie, OpIterNext
+// pushes its values and the ...OrPop jumps pop
+func stackEffect(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal, code.OpGetLocal, code.OpGetFree,
+		code.OpGetBuiltin, code.OpCurrentClosure, code.OpCaptureLocal, code.OpCaptureFree:
+		return 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow, code.OpBitAnd, code.OpBitOr,
+		code.OpBitXor, code.OpShiftLeft, code.OpShiftRight, code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+		code.OpGreaterThanOrEqual, code.OpLessThan, code.OpLessThanOrEqual, code.OpIndex, code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpSetFree, code.OpJumpNotTruthy,
+		code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop, code.OpReturnValue, code.OpThrow:
+		return -1
+	case code.OpArray, code.OpHash:
+		return 1 - operands[0]
+	case code.OpCall:
+		return -operands[0] // The function and its arguments are replaced by the result
+	case code.OpClosure:
+		return 1 - operands[1]
+	case code.OpIterNext:
+		return operands[1]
+	case code.OpSetIndex, code.OpCompoundSetIndex:
+		return -2
+	default: // OpMinus, OpBang, OpBitNot, OpIter, OpJump, OpReturn, OpSetLocalKeep
+		return 0
+	}
+}
+
+// Binary operators applied by compound assignments, eg, x += 1 is x = x + 1
+var compoundOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+// The right operand is only evaluated when the left one doesn't decide the result, ie, when it is truthy for && and
+// not truthy for ||. Either way the result is the last operand evaluated, not a boolean
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	jump := code.OpJumpNotTruthyOrPop
+	if node.Operator == "||" {
+		jump = code.OpJumpTruthyOrPop
+	}
+	jumpPos := c.emit(jump, 9999)
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	afterRightPos := len(c.currentInstructions())
+	c.changeOperand(jumpPos, afterRightPos)
+
+	return nil
+}
+
+// Assignments are expressions that evaluate to the assigned value, so that a = b = 1 works. Globals, locals, captured
+// variables and the elements of arrays and hashes can be assigned. A closure shares the variables it captures with
+// the function they belong to, see object.Cell, so an assignment on either side is seen by the other
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	op, compound := compoundOperators[node.Operator]
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return diagnostic.Errorf(diagnostic.UndefinedVariable, diagnostic.TokenSpan(target.Token), "cannot assign to undefined variable %s", target.Value)
+		}
+		// The name of a function inside its own body, possibly captured by a closure nested in it. A global function
+		// can still rebind its own global
+		if captured, table := c.symbolTable.capturedSymbol(symbol); captured.Scope == FunctionScope {
+			if outer, ok := table.Outer.Resolve(target.Value); ok && outer.Scope == GlobalScope {
+				symbol = outer
+			} else {
+				symbol = captured
+			}
+		}
+
+		switch symbol.Scope {
+		case GlobalScope, LocalScope, FreeScope:
+		case BuiltinScope:
+			return diagnostic.Errorf(diagnostic.InvalidAssignment, diagnostic.TokenSpan(target.Token), "cannot assign to builtin %s", target.Value)
+		default:
+			return diagnostic.Errorf(diagnostic.InvalidAssignment, diagnostic.TokenSpan(target.Token), "cannot assign to function %s inside its own body", target.Value)
+		}
+
+		if compound {
+			c.loadSymbol(symbol)
+		}
+
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.setSymbol(symbol)
+		c.loadSymbol(symbol)
+
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(code.OpCompoundSetIndex, int(op))
+		} else {
+			c.emit(code.OpSetIndex)
+		}
+	}
+
+	return nil
+}
+
+// Define the names of a run of functions bound by let one after the other before compiling any of them, so that they
+// can call each other. The run starts at the first statement and ends before anything that is not such a let. Only
+// function literals are compiled while a name is defined but not bound, so code before the run still sees the name
+// as it was. A closure that captures a sibling before it is bound shares the sibling's cell, so it sees the function
+// once the let binds it
+func (c *Compiler) hoistFunctionNames(statements []ast.Statement) {
+	scope := c.scopes[c.scopeIndex]
+	if let, ok := statements[0].(*ast.LetStatement); ok {
+		if _, hoisted := scope.hoisted[let]; hoisted { // Part of a run that started earlier
+			return
+		}
+	}
+
+	for _, s := range statements {
+		let, ok := s.(*ast.LetStatement)
+		if !ok {
+			return
+		}
+		if _, ok := let.Value.(*ast.FunctionLiteral); !ok {
+			return
+		}
+		scope.hoisted[let] = c.symbolTable.Define(let.Name.Value)
+	}
+}
+
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	handlers := c.scopes[c.scopeIndex].handlers
+	if c.peephole { // The main program is finished once its bytecode is taken
+		instructions, sourceMap, handlers = optimizeInstructions(instructions, sourceMap, handlers)
+	}
+
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		SourceMap:    sourceMap,
+		GlobalNames:  c.symbolTable.SlotNames(),
+		Handlers:     handlers,
 	}
 }
 
@@ -328,20 +836,31 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
 	compiler.constants = constants
+	compiler.internConstants(constants) // So that a line in the REPL reuses the constants of the ones before it
 	return compiler
 }
 
-// Adding constant to constant pool
+// Adding constant to constant pool. When optimizing, an equal constant already in the pool is used instead
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := constantKey(obj)
+	if index, found := c.constantIndexes[key]; ok && found && c.optimize {
+		return index
+	}
+
 	c.constants = append(c.constants, obj)
+	if ok {
+		c.constantIndexes[key] = len(c.constants) - 1
+	}
 	return len(c.constants) - 1
 }
 
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
+	c.scopes[c.scopeIndex].stackDepth += stackEffect(op, operands)
 
 	c.setLastInstruction(op, pos)
+	c.addSourceMapping(pos)
 
 	return pos
 }
@@ -355,6 +874,31 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	return posNewInstruction
 }
 
+// Only a change of position is recorded, the instructions in between share the previous entry
+func (c *Compiler) addSourceMapping(offset int) {
+	if !c.position.IsValid() {
+		return
+	}
+
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	if len(sourceMap) > 0 && sourceMap[len(sourceMap)-1].Pos == c.position {
+		return
+	}
+
+	c.scopes[c.scopeIndex].sourceMap = append(sourceMap, code.SourceMapEntry{Offset: offset, Pos: c.position})
+}
+
+// Instructions emitted while compiling the node (and not one of its children) are mapped back to it, until the
+// returned function restores the position of the enclosing node
+func (c *Compiler) trackPosition(node ast.Node) func() {
+	previous := c.position
+	if pos := ast.ErrorPosition(node); pos.IsValid() {
+		c.position = pos
+	}
+
+	return func() { c.position = previous }
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction    // The previous instruction is set from the last instruction of the instruction passed
 	last := EmittedInstruction{Opcode: op, Position: pos} // The instruction passed now becomes the last instruction of the current instruction
@@ -363,6 +907,97 @@ func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	c.scopes[c.scopeIndex].lastInstruction = last
 }
 
+func (c *Compiler) enterLoop(start int) *loopContext {
+	loop := &loopContext{start: start, depth: c.stackDepth(), tries: len(c.scopes[c.scopeIndex].tries)}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+func (c *Compiler) leaveLoop() {
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+// The innermost loop of the current function, nil if there is none
+func (c *Compiler) currentLoop() *loopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) enterTry(finally *ast.BlockStatement) *tryContext {
+	try := &tryContext{finally: finally, start: len(c.currentInstructions())}
+	c.scopes[c.scopeIndex].tries = append(c.scopes[c.scopeIndex].tries, try)
+	return try
+}
+
+// Closes the protected range of the innermost try
+func (c *Compiler) leaveTry() {
+	tries := c.scopes[c.scopeIndex].tries
+	try := tries[len(tries)-1]
+	try.ranges = append(try.ranges, [2]int{try.start, len(c.currentInstructions())})
+	c.scopes[c.scopeIndex].tries = tries[:len(tries)-1]
+}
+
+// Point the protected ranges of a try at the handler that starts at the next instruction
+func (c *Compiler) addHandlers(try *tryContext, depth int) {
+	handler := len(c.currentInstructions())
+	for _, r := range try.ranges {
+		if r[0] < r[1] {
+			h := code.Handler{Start: r[0], End: r[1], Handler: handler, StackDepth: depth}
+			c.scopes[c.scopeIndex].handlers = append(c.scopes[c.scopeIndex].handlers, h)
+		}
+	}
+}
+
+// Before jumping out of the trys from index outermost on, run their finally blocks, innermost first. Each block is
+// compiled outside of its own try, but still inside the trys enclosing it
+func (c *Compiler) compileFinallyBlocks(outermost int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= outermost; i-- {
+		try := tries[i]
+		if try.finally == nil {
+			continue
+		}
+
+		try.ranges = append(try.ranges, [2]int{try.start, len(c.currentInstructions())})
+		c.scopes[c.scopeIndex].tries = tries[:i]
+
+		err := c.Compile(try.finally)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reopen the protected ranges split by compileFinallyBlocks, once the jump out is emitted
+func (c *Compiler) resumeTries(outermost int) {
+	for _, try := range c.scopes[c.scopeIndex].tries[outermost:] {
+		try.start = len(c.currentInstructions())
+	}
+}
+
+func (c *Compiler) stackDepth() int {
+	return c.scopes[c.scopeIndex].stackDepth
+}
+
+func (c *Compiler) setStackDepth(depth int) {
+	c.scopes[c.scopeIndex].stackDepth = depth
+}
+
+// Pop whatever is on the stack above depth
+func (c *Compiler) dropStack(depth int) {
+	for c.stackDepth() > depth {
+		c.emit(code.OpPop)
+	}
+}
+
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	if len(c.currentInstructions()) == 0 {
 		return false
@@ -379,9 +1014,20 @@ func (c *Compiler) removeLastPop() {
 
 	// Remove the last OpPop by rewriting instructions[] with instructions[] till the lastInstruction position
 	c.scopes[c.scopeIndex].instructions = new
+	c.truncateSourceMap(last.Position)
 
 	// Replace the last instruction with previous instruction, ie, the one before that to keep proper track, since we removed the actual last instruction (OpPop)
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].stackDepth++ // The value the OpPop would have taken stays on the stack
+}
+
+// Drop the source map entries of instructions that were removed
+func (c *Compiler) truncateSourceMap(length int) {
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	for len(sourceMap) > 0 && sourceMap[len(sourceMap)-1].Offset >= length {
+		sourceMap = sourceMap[:len(sourceMap)-1]
+	}
+	c.scopes[c.scopeIndex].sourceMap = sourceMap
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -393,9 +1039,9 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 }
 
 // Replace the operand of an instruction. The assumption here is that we only replace instructions of the same type with same length.
-func (c *Compiler) changeOperand(opPos int, operand int) {
+func (c *Compiler) changeOperand(opPos int, operands ...int) { // All operands of the instruction have to be given
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	newInstruction := code.Make(op, operands...)
 
 	c.replaceInstruction(opPos, newInstruction)
 }
@@ -405,6 +1051,7 @@ func (c *Compiler) enterScope() {
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
+		hoisted:             make(map[*ast.LetStatement]Symbol),
 	}
 	c.scopes = append(c.scopes, scope)
 	c.scopeIndex++ // Bumping up scope index to indicate the addition of a new functional scope
@@ -423,9 +1070,57 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+func (c *Compiler) setSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// Emit the correct "get" instruction depending on where the symbol lives
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// Push what a closure captures a symbol as: the cell of a variable, or the closure itself for a function's own name.
+// Globals and builtins are never captured
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
 
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
+
+func symbolNames(symbols []Symbol) []string {
+	var names []string
+	for _, s := range symbols {
+		names = append(names, s.Name)
+	}
+	return names
+}
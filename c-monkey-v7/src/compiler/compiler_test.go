@@ -3,10 +3,15 @@ package compiler
 import (
 	"Compiler/c-monkey-v7/src/ast"
 	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/diagnostic"
 	"Compiler/c-monkey-v7/src/lexer"
 	"Compiler/c-monkey-v7/src/object"
 	"Compiler/c-monkey-v7/src/parser"
+	"Compiler/c-monkey-v7/src/token"
+	"errors"
 	"fmt"
+	"math/big"
+	"reflect"
 	"testing"
 )
 
@@ -16,7 +21,18 @@ type compilerTestCase struct {
 	expectedInstructions []code.Instructions
 }
 
+// Compiles without optimizations, so that the fixtures show the bytecode of every construct as written
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+	runCompilerTestsWith(t, tests, false)
+}
+
+func runOptimizedCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+	runCompilerTestsWith(t, tests, true)
+}
+
+func runCompilerTestsWith(t *testing.T, tests []compilerTestCase, optimize bool) {
 	t.Helper()
 	// Marks this as a helper function
 	// So technically, all the assertions can be done here, instead of in TestIntegerArithmetic
@@ -26,6 +42,9 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 		program := parse(tt.input)
 
 		compiler := New()
+		if !optimize {
+			compiler.DisableOptimizations()
+		}
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -41,6 +60,9 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 		if err != nil {
 			t.Fatalf("testConstants failed: %s", err)
 		}
+
+		// Every fixture must also survive being written to and read back from a .mbc file
+		testMarshalRoundTrip(t, bytecode)
 	}
 }
 
@@ -91,12 +113,27 @@ func testConstants(_ *testing.T, expected []interface{}, actual []object.Object)
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s", i, err)
 			}
 
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s", i, err)
+			}
+
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
 				return fmt.Errorf("constant %d - testStringObject failed: %s", i, err)
 			}
 
+		case *big.Int:
+			result, ok := actual[i].(*object.BigInteger)
+			if !ok {
+				return fmt.Errorf("constant %d - not a big integer: %T", i, actual[i])
+			}
+			if result.Value.Cmp(constant) != 0 {
+				return fmt.Errorf("constant %d - wrong value. got=%s, want=%s", i, result.Value, constant)
+			}
+
 		case []code.Instructions:
 			fn, ok := actual[i].(*object.CompiledFunction)
 			if !ok {
@@ -126,6 +163,19 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+
+	return nil
+}
+
 func testStringObject(expected string, actual object.Object) error {
 	result, ok := actual.(*object.String)
 	if !ok {
@@ -181,6 +231,86 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "2 % 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 ** 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPow),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 & 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitAnd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 | 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitOr),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 ^ 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitXor),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 << 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShiftLeft),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 >> 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShiftRight),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1.5 * 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "1; 2",
 			expectedConstants: []interface{}{1, 2},
@@ -200,6 +330,15 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "~1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -235,21 +374,21 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
 		{
 			input:             "1 <= 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpLessThanOrEqual),
 				code.Make(code.OpPop),
 			},
 		},
@@ -317,6 +456,45 @@ func TestBooleanExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestLogicalExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false;",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthyOrPop, 5),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 || 2 && 3;",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpTruthyOrPop, 15),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpJumpNotTruthyOrPop, 15),
+				// 0012
+				code.Make(code.OpConstant, 2),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -586,7 +764,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -602,7 +780,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -618,7 +796,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -696,7 +874,7 @@ func TestFunctionsWithoutReturnValue(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -716,7 +894,7 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1), // Compiled function
+				code.Make(code.OpClosure, 1, 0), // Compiled function
 				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
@@ -734,7 +912,7 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -755,7 +933,7 @@ func TestFunctionCalls(t *testing.T) {
 				24,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -783,7 +961,7 @@ func TestFunctionCalls(t *testing.T) {
 				26,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -815,7 +993,7 @@ func TestLetStatementScopes(t *testing.T) {
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -836,7 +1014,7 @@ func TestLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -862,7 +1040,174 @@ func TestLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			fn(a) {
+				fn(b) {
+					a + b
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			fn(a) {
+				fn(b) {
+					fn(c) {
+						a + b + c
+					}
+				}
+			};
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let global = 55;
+
+			fn() {
+				let a = 66;
+
+				fn() {
+					let b = 77;
+
+					fn() {
+						let c = 88;
+
+						global + a + b + c;
+					}
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				55,
+				66,
+				77,
+				88,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 3),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 4, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 5, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 6, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			len([]);
+			push([], 1);
+			`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 5),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { len([]) }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -870,3 +1215,1165 @@ func TestLetStatementScopes(t *testing.T) {
 
 	runCompilerTests(t, tests)
 }
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let countDown = fn(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let one = 1;
+			let a = fn() { b() };
+			let b = fn() { one };
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 2), // b is defined along with a, before either is bound
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 2),
+			},
+		},
+		{
+			input: `
+			fn() {
+				let a = fn() { b() };
+				let b = fn() { a() };
+			}
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 1), // b is not bound yet, a shares its cell and sees it once it is
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionalsWithoutValue(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// Blocks that don't end in an expression still have to leave a value for the OpPop
+			input: `
+			if (true) { let a = 1; } else { };
+			`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			while (true) { 10; }; 20;
+			`,
+			expectedConstants: []interface{}{10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11), // Out of the loop
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0), // Back to the condition
+				// 0011
+				code.Make(code.OpConstant, 1),
+				// 0014
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			while (true) { if (false) { break; } else { continue; } }
+			`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 23),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 15),
+				// 0008
+				code.Make(code.OpJump, 23), // break
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpJump, 19),
+				// 0015
+				code.Make(code.OpJump, 0), // continue
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			// break and continue belong to the innermost loop
+			input: `
+			while (true) { while (false) { break; } continue; }
+			`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 20),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0008
+				code.Make(code.OpJump, 14), // break from the inner loop
+				// 0011
+				code.Make(code.OpJump, 4),
+				// 0014
+				code.Make(code.OpJump, 0), // continue the outer loop
+				// 0017
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input: `
+			fn() { let i = 0; while (i < 3) { i = i + 1; } }
+			`,
+			expectedConstants: []interface{}{
+				0,
+				3,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					// 0005
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpLessThan),
+					code.Make(code.OpJumpNotTruthy, 28),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 5),
+					// 0028
+					code.Make(code.OpReturn), // A loop leaves no value to return
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			for (x in [1]) { x; }
+			`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 21, 1), // Pops the iterator and jumps out once it is done
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpGetGlobal, 0),
+				// 0017
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpJump, 7),
+			},
+		},
+		{
+			input: `
+			fn(h) { for (k, v in h) { if (v) { break; } else { continue; } } }
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpIter),
+					// 0003
+					code.Make(code.OpIterNext, 32, 2),
+					code.Make(code.OpSetLocal, 2), // The value is on top, so it is bound first
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 2),
+					// 0013
+					code.Make(code.OpJumpNotTruthy, 24),
+					code.Make(code.OpPop),      // Drop the iterator
+					code.Make(code.OpJump, 32), // before breaking out
+					code.Make(code.OpNull),
+					// 0021
+					code.Make(code.OpJump, 28),
+					// 0024
+					code.Make(code.OpJump, 3), // continue
+					code.Make(code.OpNull),
+					// 0028
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 3),
+					// 0032
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			try { 1 } catch (e) { e } finally { 2 }
+			`,
+			expectedConstants: []interface{}{1, 2, 2, 2}, // The finally block is copied onto every way out
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1), // The body completed
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 30),
+				// 0011, the catch handler
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpConstant, 2), // The catch block completed
+				code.Make(code.OpPop),
+				code.Make(code.OpJump, 30),
+				// 0025, the finally handler
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+				code.Make(code.OpThrow), // The error is still on top of the stack
+				// 0030
+			},
+		},
+		{
+			input: `
+			fn() { try { return 1; } finally { 2 } }
+			`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				2,
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1), // The return runs the finally block before it leaves
+					code.Make(code.OpPop),
+					code.Make(code.OpReturnValue),
+					// 0008
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 20),
+					// 0015
+					code.Make(code.OpConstant, 3),
+					code.Make(code.OpPop),
+					code.Make(code.OpThrow),
+					// 0020
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 4, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			throw "oops"
+			`,
+			expectedConstants: []interface{}{"oops"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpThrow),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestExceptionHandlers(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedHandlers code.HandlerTable // Of main, or of the first function if there is one
+	}{
+		{
+			"try { 1 } catch (e) { e } finally { 2 }",
+			code.HandlerTable{
+				{Start: 0, End: 4, Handler: 11, StackDepth: 0},   // The body, caught by catch
+				{Start: 14, End: 18, Handler: 25, StackDepth: 0}, // The catch block, caught by finally
+			},
+		},
+		{
+			// The copy of the finally block run by the return is not protected by its own try
+			"fn() { try { return 1; } finally { 2 } }",
+			code.HandlerTable{{Start: 0, End: 3, Handler: 15, StackDepth: 0}},
+		},
+		{
+			// The 1 is still on the stack when the try runs
+			"[1, if (true) { try { 2 } catch (e) { }; 3 }]",
+			code.HandlerTable{{Start: 7, End: 11, Handler: 14, StackDepth: 1}},
+		},
+		{
+			// So is the iterator of the loop, which break drops after running the finally block
+			"for (x in [1]) { try { x; break; } finally { 3 } }",
+			code.HandlerTable{{Start: 14, End: 18, Handler: 33, StackDepth: 1}},
+		},
+		{
+			// Inner handlers come first, so the first match is the innermost
+			"try { try { 1 } catch (e) { 2 } } catch (e) { 3 }",
+			code.HandlerTable{
+				{Start: 0, End: 4, Handler: 7, StackDepth: 0},
+				{Start: 0, End: 17, Handler: 20, StackDepth: 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		compiler.DisableOptimizations()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+		handlers := bytecode.Handlers
+		for _, c := range bytecode.Constants {
+			if fn, ok := c.(*object.CompiledFunction); ok {
+				handlers = fn.Handlers
+				break
+			}
+		}
+
+		if !reflect.DeepEqual(handlers, tt.expectedHandlers) {
+			t.Errorf("wrong handlers for %q.\nwant=%+v\ngot=%+v", tt.input, tt.expectedHandlers, handlers)
+		}
+
+		testMarshalRoundTrip(t, bytecode)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0), // The assignment is an expression, so its value is left behind
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let x = 1; x += 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] *= 3;",
+			expectedConstants: []interface{}{1, 0, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCompoundSetIndex, int(code.OpMul)),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let h = {}; h["a"] = 1;`,
+			expectedConstants: []interface{}{"a", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-2 ** 2 - ~0",
+			expectedConstants: []interface{}{-3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2 == true; !5; !!true; 1 >= 2",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "k" + "ey"`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; x + 2 * 3",
+			expectedConstants: []interface{}{1, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The condition folds to false, after which the peephole optimizer drops the branch that can't run
+			input: "fn() { if (1 > 2) { return [2 ** 10] } }",
+			expectedConstants: []interface{}{
+				1024,
+				[]code.Instructions{
+					code.Make(code.OpNull),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Errors are left for the VM to raise
+			input:             "1 / 0; 2 ** -1",
+			expectedConstants: []interface{}{1, 0, 2, -1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPow),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// So is a result that overflows into a big integer, which has no literal
+			input:             "9223372036854775807 + 1",
+			expectedConstants: []interface{}{9223372036854775807, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" == "a"; "a" != "b"`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runOptimizedCompilerTests(t, tests)
+}
+
+func TestDisablePeephole(t *testing.T) {
+	compiler := New()
+	compiler.DisablePeephole()
+	err := compiler.Compile(parse(`"a"; if (1 > 2) { "a" }`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	// Still folded and interned, but the branch that can't run is kept
+	expectedInstructions := []code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpFalse),
+		code.Make(code.OpJumpNotTruthy, 14),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpJump, 15),
+		code.Make(code.OpNull),
+		code.Make(code.OpPop),
+	}
+	err = testInstructions(expectedInstructions, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	err = testConstants(t, []interface{}{"a"}, bytecode.Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
+func TestConstantFoldingLeavesInputAlone(t *testing.T) {
+	input := `let f = fn(x) { if (1 < 2) { x + 2 * 3 } else { [-1, {"a" + "b": !true}] } }; f(1 + 1); let a = [0]; a[0 + 0] = 1 + 2`
+	program := parse(input)
+	before := program.String()
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	if after := program.String(); after != before {
+		t.Errorf("compiling changed the program.\nbefore=%q\nafter=%q", before, after)
+	}
+}
+
+func TestConstantInterning(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"name"; 1; "name"; 1.5; 1; 1.5`,
+			expectedConstants: []interface{}{"name", 1, 1.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { "name" }; ["name", fn() { "name" }]`,
+			expectedConstants: []interface{}{
+				"name",
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 2, 0), // Not shared, the two functions are at different places in the source
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "18446744073709551616; 18446744073709551616; 9223372036854775807",
+			expectedConstants: []interface{}{
+				new(big.Int).Lsh(big.NewInt(1), 64),
+				9223372036854775807,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runOptimizedCompilerTests(t, tests)
+}
+
+func TestConstantInterningAcrossCompilations(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	constants := []object.Object{}
+
+	lines := []string{`let f = fn() { "a" }; "b"`, `let f = fn() { "a" }; "b"`}
+	for _, line := range lines {
+		compiler := NewWithState(symbolTable, constants)
+		if err := compiler.Compile(parse(line)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = compiler.Bytecode().Constants
+	}
+
+	// Redefining f compiles to the very same function, so the second line reuses all of the first line's constants
+	err := testConstants(t, []interface{}{"a", []code.Instructions{code.Make(code.OpConstant, 0), code.Make(code.OpReturnValue)}, "b"}, constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
+func TestCompactConstants(t *testing.T) {
+	inner := &object.CompiledFunction{Instructions: concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 4),
+		code.Make(code.OpReturnValue),
+	})}
+	outer := &object.CompiledFunction{Instructions: concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpClosure, 5, 0),
+		code.Make(code.OpReturnValue),
+	})}
+
+	constants := []object.Object{
+		&object.Integer{Value: 0}, // Only used by main programs that already ran
+		outer,
+		&object.String{Value: "kept"},
+		&object.Integer{Value: 3},
+		&object.Integer{Value: 4},
+		inner,
+	}
+
+	compacted := CompactConstants(constants)
+
+	expected := []object.Object{outer, constants[2], constants[4], inner}
+	if !reflect.DeepEqual(compacted, expected) {
+		t.Fatalf("wrong constants. want=%v, got=%v", expected, compacted)
+	}
+
+	err := testInstructions([]code.Instructions{code.Make(code.OpConstant, 1), code.Make(code.OpClosure, 3, 0), code.Make(code.OpReturnValue)}, outer.Instructions)
+	if err != nil {
+		t.Errorf("outer function not renumbered: %s", err)
+	}
+	err = testInstructions([]code.Instructions{code.Make(code.OpConstant, 2), code.Make(code.OpReturnValue)}, inner.Instructions)
+	if err != nil {
+		t.Errorf("inner function not renumbered: %s", err)
+	}
+}
+
+func TestPeepholeOptimizer(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// x is read right after both the let and the assignment
+			input: "fn() { let x = 1; x = x + 1; x }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocalKeep, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetLocalKeep, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// An assignment statement drops the value it would leave
+			input: "fn(x) { x = 2; 3 }",
+			expectedConstants: []interface{}{
+				2,
+				3,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "while (true) { 1 }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpPop),
+				// 0004
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             "while (false) { 1 }; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The inner if jumps straight to the end of the outer one
+			input:             "let a = true; if (a) { if (a) { 1 } else { 2 } } else { 3 }",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpSetGlobal, 0),
+				// 0004
+				code.Make(code.OpGetGlobal, 0),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 28),
+				// 0010
+				code.Make(code.OpGetGlobal, 0),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 22),
+				// 0016
+				code.Make(code.OpConstant, 0),
+				// 0019
+				code.Make(code.OpJump, 31),
+				// 0022
+				code.Make(code.OpConstant, 1),
+				// 0025
+				code.Make(code.OpJump, 31),
+				// 0028
+				code.Make(code.OpConstant, 2),
+				// 0031
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// A short circuit that fails decides the ones after it the same way
+			input:             "let a = 1; a && a && a",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpJumpNotTruthyOrPop, 21),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpJumpNotTruthyOrPop, 21),
+				// 0018
+				code.Make(code.OpGetGlobal, 0),
+				// 0021
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { return 1; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The OpNull and OpJump over the missing else branch can't be reached after continue
+			input:             "for (x in [1]) { if (x) { continue } }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 28, 1),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpGetGlobal, 0),
+				// 0017
+				code.Make(code.OpJumpNotTruthy, 23),
+				// 0020
+				code.Make(code.OpJump, 7),
+				// 0023
+				code.Make(code.OpNull),
+				// 0024
+				code.Make(code.OpPop),
+				// 0025
+				code.Make(code.OpJump, 7),
+			},
+		},
+	}
+
+	runOptimizedCompilerTests(t, tests)
+}
+
+func TestPeepholeOptimizerMovesOffsets(t *testing.T) {
+	input := "fn() { let x = 1; try { x = x / 0 } catch (e) { x } }"
+
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn := compiler.Bytecode().Constants[2].(*object.CompiledFunction)
+
+	// The OpGetLocal and OpPop of the assignment statement are gone, and so is the OpJump of the catch block to the
+	// OpReturn right after it, which moves up from 27 to 21
+	expectedHandlers := code.HandlerTable{{Start: 5, End: 13, Handler: 16, StackDepth: 0}}
+	if !reflect.DeepEqual(fn.Handlers, expectedHandlers) {
+		t.Errorf("wrong handlers.\nwant=%+v\ngot=%+v", expectedHandlers, fn.Handlers)
+	}
+
+	positions := []struct {
+		offset int
+		column int
+	}{
+		{10, 31}, // OpDiv
+		{11, 27}, // OpSetLocal for the assignment
+		{13, 19}, // OpJump to the end of the try
+		{18, 49}, // OpGetLocal in the catch block
+		{21, 1},  // OpReturn
+	}
+	for _, tt := range positions {
+		pos, _ := fn.SourceMap.PositionFor(tt.offset)
+		if pos.Column != tt.column {
+			t.Errorf("wrong position for offset %d. want=1:%d, got=%d:%d", tt.offset, tt.column, pos.Line, pos.Column)
+		}
+	}
+}
+
+func TestCompilerDiagnostics(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedCode  diagnostic.Code
+		expectedError string
+	}{
+		{"let x = 1;\nx + y", diagnostic.UndefinedVariable, "2:5: undefined variable y"},
+		{"fn() {\n  let a = 1;\n  b\n}", diagnostic.UndefinedVariable, "3:3: undefined variable b"},
+		{"break;", diagnostic.OutsideLoop, "1:1: break outside of a loop"},
+		{"if (true) { continue; }", diagnostic.OutsideLoop, "1:13: continue outside of a loop"},
+		{"while (true) { fn() { break; } }", diagnostic.OutsideLoop, "1:23: break outside of a loop"},
+		// Only a run of functions bound one after the other can see the ones after them
+		{"let early = fn() { later };\nlet x = early();\nlet later = fn() { 1 };", diagnostic.UndefinedVariable, "1:20: undefined variable later"},
+		{"fn() { let fs = [fn() { later() }]; let later = fn() { 1 }; }", diagnostic.UndefinedVariable, "1:25: undefined variable later"},
+		{"let x = 1;\ny = 2", diagnostic.UndefinedVariable, "2:1: cannot assign to undefined variable y"},
+		{"len = 1", diagnostic.InvalidAssignment, "1:1: cannot assign to builtin len"},
+		{"fn() { let f = fn() { f = 1; }; }", diagnostic.InvalidAssignment, "1:23: cannot assign to function f inside its own body"},
+		{"fn() { let f = fn() { fn() { f = 1; } }; }", diagnostic.InvalidAssignment, "1:30: cannot assign to function f inside its own body"},
+		{"let x = 1 + ;", diagnostic.SyntaxError, "1:13: cannot compile an expression that failed to parse"},
+		{"let 5;", diagnostic.SyntaxError, "1:1: cannot compile a statement that failed to parse"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q, got none", tt.input)
+		}
+
+		var d *diagnostic.Diagnostic
+		if !errors.As(err, &d) {
+			t.Fatalf("compiler error is not a diagnostic. got=%T (%+v)", err, err)
+		}
+
+		if d.Code != tt.expectedCode {
+			t.Errorf("wrong diagnostic code. want=%s, got=%s", tt.expectedCode, d.Code)
+		}
+
+		if err.Error() != tt.expectedError {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expectedError, err.Error())
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	input := `let a = 1;
+a + 2;
+let f = fn(x) {
+  x * a
+};`
+
+	program := parse(input)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	tests := []struct {
+		sourceMap    code.SourceMap
+		offset       int
+		expectedLine int
+		expectedCol  int
+	}{
+		{bytecode.SourceMap, 0, 1, 9},  // OpConstant 0 for the 1
+		{bytecode.SourceMap, 3, 1, 1},  // OpSetGlobal for the let
+		{bytecode.SourceMap, 6, 2, 1},  // OpGetGlobal for a
+		{bytecode.SourceMap, 9, 2, 5},  // OpConstant for the 2
+		{bytecode.SourceMap, 12, 2, 3}, // OpAdd points at the operator
+		{bytecode.SourceMap, 13, 2, 1}, // OpPop for the expression statement
+		{bytecode.SourceMap, 14, 3, 9}, // OpClosure for the function literal
+	}
+
+	for _, tt := range tests {
+		pos, ok := tt.sourceMap.PositionFor(tt.offset)
+		if !ok {
+			t.Errorf("no position for offset %d", tt.offset)
+			continue
+		}
+		if pos.Line != tt.expectedLine || pos.Column != tt.expectedCol {
+			t.Errorf("wrong position for offset %d. want=%d:%d, got=%d:%d", tt.offset, tt.expectedLine, tt.expectedCol, pos.Line, pos.Column)
+		}
+	}
+
+	fn, ok := bytecode.Constants[2].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 2 is not a function. got=%T", bytecode.Constants[2])
+	}
+
+	// OpGetLocal 0, OpGetGlobal 0, OpMul, OpReturnValue
+	expected := code.SourceMap{
+		{Offset: 0, Pos: token.Position{Line: 4, Column: 3, Offset: 36}},
+		{Offset: 2, Pos: token.Position{Line: 4, Column: 7, Offset: 40}},
+		{Offset: 5, Pos: token.Position{Line: 4, Column: 5, Offset: 38}},
+		{Offset: 6, Pos: token.Position{Line: 4, Column: 3, Offset: 36}},
+	}
+
+	if len(fn.SourceMap) != len(expected) {
+		t.Fatalf("wrong function source map. want=%+v, got=%+v", expected, fn.SourceMap)
+	}
+	for i, entry := range expected {
+		if fn.SourceMap[i] != entry {
+			t.Errorf("wrong source map entry %d. want=%+v, got=%+v", i, entry, fn.SourceMap[i])
+		}
+	}
+}
+
+func TestSourceMapDropsRemovedInstructions(t *testing.T) {
+	input := "if (true) {\n  10;\n}"
+
+	program := parse(input)
+	compiler := New()
+	compiler.DisableOptimizations() // Else the whole if is optimized away
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	for _, entry := range bytecode.SourceMap {
+		if entry.Offset >= len(bytecode.Instructions) {
+			t.Errorf("source map entry past the end of the instructions: %+v", entry)
+		}
+	}
+
+	// The OpPop of `10;` was removed, so OpJump right after the constant belongs to the if expression again
+	pos, _ := bytecode.SourceMap.PositionFor(7)
+	if pos.Line != 1 || pos.Column != 1 {
+		t.Errorf("wrong position for OpJump. want=1:1, got=%d:%d", pos.Line, pos.Column)
+	}
+}
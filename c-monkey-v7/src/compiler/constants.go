@@ -0,0 +1,82 @@
+package compiler
+
+import (
+	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/object"
+)
+
+// Constants are interned by their marshaled form, which holds everything about them. A function's source map is
+// part of it, so two functions are only shared when their runtime errors would point at the same place anyway.
+// ok is false for constants that can't be marshaled, which are never shared
+func constantKey(obj object.Object) (key string, ok bool) {
+	e := &encoder{}
+	if err := e.writeConstant(obj); err != nil {
+		return "", false
+	}
+	return e.buf.String(), true
+}
+
+func (c *Compiler) internConstants(constants []object.Object) {
+	for i, obj := range constants {
+		key, ok := constantKey(obj)
+		if _, found := c.constantIndexes[key]; ok && !found {
+			c.constantIndexes[key] = i
+		}
+	}
+}
+
+/*
+CompactConstants drops the constants nothing can refer to any more and renumbers the rest. It is meant for a REPL,
+where every line compiles into the same pool: once a line has run, the constants only its main program used are
+garbage. Every compiled function is kept, since a closure of it may still be called, along with the constants the
+functions use.
+
+The instructions of the kept functions are renumbered in place, as closures point at the functions themselves, so it
+must only be called while no bytecode that uses the pool is running.
+*/
+func CompactConstants(constants []object.Object) []object.Object {
+	live := make([]bool, len(constants))
+	for i, obj := range constants {
+		if fn, ok := obj.(*object.CompiledFunction); ok {
+			live[i] = true
+			forEachConstantOperand(fn.Instructions, func(offset int, op code.Opcode, operands []int) {
+				if operands[0] < len(live) {
+					live[operands[0]] = true
+				}
+			})
+		}
+	}
+
+	compacted := []object.Object{}
+	renumbered := make([]int, len(constants))
+	for i, obj := range constants {
+		if live[i] {
+			renumbered[i] = len(compacted)
+			compacted = append(compacted, obj)
+		}
+	}
+
+	for _, obj := range compacted {
+		if fn, ok := obj.(*object.CompiledFunction); ok {
+			forEachConstantOperand(fn.Instructions, func(offset int, op code.Opcode, operands []int) {
+				if operands[0] < len(renumbered) {
+					operands[0] = renumbered[operands[0]]
+					copy(fn.Instructions[offset:], code.Make(op, operands...))
+				}
+			})
+		}
+	}
+
+	return compacted
+}
+
+// Calls fn for every instruction whose first operand is a constant index, ie, OpConstant and OpClosure
+func forEachConstantOperand(ins code.Instructions, fn func(offset int, op code.Opcode, operands []int)) {
+	for offset := 0; offset < len(ins); {
+		_, operands, width, err := code.ReadInstruction(ins, offset)
+		if op := code.Opcode(ins[offset]); err == nil && (op == code.OpConstant || op == code.OpClosure) {
+			fn(offset, op, operands)
+		}
+		offset += width
+	}
+}
@@ -0,0 +1,267 @@
+package compiler
+
+import (
+	"Compiler/c-monkey-v7/src/ast"
+	"Compiler/c-monkey-v7/src/object"
+	"Compiler/c-monkey-v7/src/token"
+	"strconv"
+)
+
+/*
+Constant folding works out operators whose operands are literals before any bytecode is emitted, so that 1 + 2 compiles
+to a single OpConstant 3 instead of two constants and an OpAdd. It covers integer and string arithmetic, integer,
+boolean and string comparisons, !, unary - and ~.
+
+A folded result has to be exactly what the VM would compute, so the arithmetic is the one the VM uses, from
+object.IntegerOperation. Anything the VM would fail on, ie, 1 / 0, is left for the VM to raise at runtime with its
+position, and so is a result that overflows into a big integer, which has no literal to fold into.
+
+The caller's tree is left as it was. Every node with expressions under it is copied, and the copy is what the compiler
+compiles, with folded expressions replaced by literals positioned where the expression started.
+*/
+func foldProgram(program *ast.Program) *ast.Program {
+	return &ast.Program{Statements: foldStatements(program.Statements), Comments: program.Comments}
+}
+
+func foldStatements(statements []ast.Statement) []ast.Statement {
+	folded := make([]ast.Statement, len(statements))
+	for i, s := range statements {
+		folded[i] = foldStatement(s)
+	}
+	return folded
+}
+
+func foldStatement(statement ast.Statement) ast.Statement {
+	switch s := statement.(type) {
+	case *ast.BlockStatement:
+		return foldBlock(s)
+	case *ast.ExpressionStatement:
+		folded := *s
+		folded.Expression = fold(s.Expression)
+		return &folded
+	case *ast.LetStatement:
+		folded := *s
+		folded.Value = fold(s.Value)
+		return &folded
+	case *ast.ReturnStatement:
+		folded := *s
+		folded.ReturnValue = fold(s.ReturnValue)
+		return &folded
+	case *ast.ThrowStatement:
+		folded := *s
+		folded.Value = fold(s.Value)
+		return &folded
+	case *ast.WhileStatement:
+		folded := *s
+		folded.Condition = fold(s.Condition)
+		folded.Body = foldBlock(s.Body)
+		return &folded
+	case *ast.ForStatement:
+		folded := *s
+		folded.Iterable = fold(s.Iterable)
+		folded.Body = foldBlock(s.Body)
+		return &folded
+	case *ast.TryStatement:
+		folded := *s
+		folded.Body = foldBlock(s.Body)
+		folded.Catch = foldBlock(s.Catch)
+		folded.Finally = foldBlock(s.Finally)
+		return &folded
+	}
+
+	return statement
+}
+
+func foldBlock(block *ast.BlockStatement) *ast.BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return &ast.BlockStatement{Token: block.Token, Statements: foldStatements(block.Statements)}
+}
+
+// Folds the expression and everything in it, returning either a copy of the expression or the literal it folded into
+func fold(expression ast.Expression) ast.Expression {
+	switch exp := expression.(type) {
+	case *ast.PrefixExpression:
+		folded := *exp
+		folded.Right = fold(exp.Right)
+		if literal := foldPrefix(&folded); literal != nil {
+			return literal
+		}
+		return &folded
+	case *ast.InfixExpression:
+		folded := *exp
+		folded.Left = fold(exp.Left)
+		folded.Right = fold(exp.Right)
+		if literal := foldInfix(&folded); literal != nil {
+			return literal
+		}
+		return &folded
+	case *ast.AssignExpression:
+		folded := *exp
+		folded.Value = fold(exp.Value)
+		if index, ok := exp.Target.(*ast.IndexExpression); ok {
+			folded.Target = fold(index)
+		}
+		return &folded
+	case *ast.IfExpression:
+		folded := *exp
+		folded.Condition = fold(exp.Condition)
+		folded.Consequence = foldBlock(exp.Consequence)
+		folded.Alternative = foldBlock(exp.Alternative)
+		return &folded
+	case *ast.FunctionLiteral:
+		folded := *exp
+		folded.Body = foldBlock(exp.Body)
+		return &folded
+	case *ast.CallExpression:
+		folded := *exp
+		folded.Function = fold(exp.Function)
+		folded.Arguments = foldExpressions(exp.Arguments)
+		return &folded
+	case *ast.ArrayLiteral:
+		folded := *exp
+		folded.Elements = foldExpressions(exp.Elements)
+		return &folded
+	case *ast.IndexExpression:
+		folded := *exp
+		folded.Left = fold(exp.Left)
+		folded.Index = fold(exp.Index)
+		return &folded
+	case *ast.HashLiteral:
+		folded := *exp
+		folded.Pairs = make(map[ast.Expression]ast.Expression, len(exp.Pairs))
+		for key, value := range exp.Pairs {
+			folded.Pairs[fold(key)] = fold(value)
+		}
+		return &folded
+	}
+
+	return expression
+}
+
+func foldExpressions(expressions []ast.Expression) []ast.Expression {
+	folded := make([]ast.Expression, len(expressions))
+	for i, e := range expressions {
+		folded[i] = fold(e)
+	}
+	return folded
+}
+
+// nil if the expression can't be folded
+func foldPrefix(exp *ast.PrefixExpression) ast.Expression {
+	switch exp.Operator {
+	case "!":
+		switch right := exp.Right.(type) {
+		case *ast.Boolean:
+			return booleanLiteral(exp, !right.Value)
+		case *ast.IntegerLiteral, *ast.StringLiteral: // Always truthy
+			return booleanLiteral(exp, false)
+		}
+	case "-":
+		if right, ok := exp.Right.(*ast.IntegerLiteral); ok {
+			return integerLiteral(exp, object.NegateInteger(&object.Integer{Value: right.Value}))
+		}
+	case "~":
+		if right, ok := exp.Right.(*ast.IntegerLiteral); ok {
+			return integerLiteral(exp, object.BitNotInteger(&object.Integer{Value: right.Value}))
+		}
+	}
+
+	return nil
+}
+
+// nil if the expression can't be folded
+func foldInfix(exp *ast.InfixExpression) ast.Expression {
+	switch left := exp.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := exp.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		l, r := &object.Integer{Value: left.Value}, &object.Integer{Value: right.Value}
+
+		if comparison, ok := compareIntegers(exp.Operator, l, r); ok {
+			return booleanLiteral(exp, comparison)
+		}
+
+		result, err := object.IntegerOperation(exp.Operator, l, r)
+		if err != nil {
+			return nil
+		}
+		return integerLiteral(exp, result)
+
+	case *ast.Boolean:
+		right, ok := exp.Right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+
+		switch exp.Operator {
+		case "==":
+			return booleanLiteral(exp, left.Value == right.Value)
+		case "!=":
+			return booleanLiteral(exp, left.Value != right.Value)
+		}
+
+	case *ast.StringLiteral:
+		right, ok := exp.Right.(*ast.StringLiteral)
+		if !ok {
+			return nil
+		}
+
+		switch exp.Operator {
+		case "+":
+			value := left.Value + right.Value
+			return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value, Pos: exp.Pos()}, Value: value}
+		case "==":
+			return booleanLiteral(exp, left.Value == right.Value)
+		case "!=":
+			return booleanLiteral(exp, left.Value != right.Value)
+		}
+	}
+
+	return nil
+}
+
+// ok is false if operator is not a comparison
+func compareIntegers(operator string, left, right object.Object) (result bool, ok bool) {
+	comparison := object.CompareIntegers(left, right)
+
+	switch operator {
+	case "==":
+		return comparison == 0, true
+	case "!=":
+		return comparison != 0, true
+	case "<":
+		return comparison < 0, true
+	case "<=":
+		return comparison <= 0, true
+	case ">":
+		return comparison > 0, true
+	case ">=":
+		return comparison >= 0, true
+	default:
+		return false, false
+	}
+}
+
+// nil unless value is an *object.Integer. A big integer stays an expression and is computed by the VM
+func integerLiteral(exp ast.Expression, value object.Object) ast.Expression {
+	integer, ok := value.(*object.Integer)
+	if !ok {
+		return nil
+	}
+
+	literal := strconv.FormatInt(integer.Value, 10)
+	return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal, Pos: exp.Pos()}, Value: integer.Value}
+}
+
+func booleanLiteral(exp ast.Expression, value bool) ast.Expression {
+	tok := token.Token{Type: token.TRUE, Literal: "true", Pos: exp.Pos()}
+	if !value {
+		tok = token.Token{Type: token.FALSE, Literal: "false", Pos: exp.Pos()}
+	}
+
+	return &ast.Boolean{Token: tok, Value: value}
+}
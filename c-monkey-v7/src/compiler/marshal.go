@@ -0,0 +1,358 @@
+package compiler
+
+import (
+	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/object"
+	"Compiler/c-monkey-v7/src/token"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+/*
+Compiled bytecode can be written to a .mbc file and loaded back, so programs don't have to be compiled on every run.
+All numbers are big endian, like the operands in code.Instructions. The layout is:
+
+	magic          4 bytes, "\x7fMBC"
+	format version uint16, FormatVersion
+	opcode version uint16, code.Version
+	constants      uint32 count, then every constant as a 1 byte tag followed by its value
+	instructions   uint32 length, then the raw instructions of main
+	source map     uint32 count, then every entry of main's source map
+	global names   uint32 count, then every name
+	handlers       uint32 count, then every exception handler of main as its start, end, handler and stack depth
+
+Integers are int64, big integers are a sign byte (1 if negative) followed by their magnitude stored like a string,
+floats are the IEEE 754 bits of a float64, strings are a uint32 length followed by the bytes, and functions hold their
+name, locals, parameters, instructions, source map, local names, free names and handlers.
+*/
+
+const FormatVersion = 5
+
+var magic = []byte{0x7f, 'M', 'B', 'C'}
+
+// Tags of the constants in the constant pool
+const (
+	integerTag byte = iota + 1
+	stringTag
+	compiledFunctionTag
+	floatTag
+	bigIntegerTag
+)
+
+func Marshal(bytecode *Bytecode) ([]byte, error) {
+	e := &encoder{}
+
+	e.buf.Write(magic)
+	e.writeUint16(FormatVersion)
+	e.writeUint16(code.Version)
+
+	e.writeUint32(len(bytecode.Constants))
+	for i, c := range bytecode.Constants {
+		err := e.writeConstant(c)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	e.writeBytes(bytecode.Instructions)
+	e.writeSourceMap(bytecode.SourceMap)
+	e.writeStrings(bytecode.GlobalNames)
+	e.writeHandlers(bytecode.Handlers)
+
+	return e.buf.Bytes(), nil
+}
+
+func Unmarshal(data []byte) (*Bytecode, error) {
+	d := &decoder{data: data}
+
+	if !bytes.HasPrefix(data, magic) {
+		return nil, fmt.Errorf("not a bytecode file: bad magic header")
+	}
+	d.pos = len(magic)
+
+	formatVersion := d.readUint16()
+	if d.err == nil && formatVersion != FormatVersion {
+		return nil, fmt.Errorf("unsupported bytecode format version %d, want %d", formatVersion, FormatVersion)
+	}
+
+	opcodeVersion := d.readUint16()
+	if d.err == nil && opcodeVersion != code.Version {
+		return nil, fmt.Errorf("bytecode was compiled for opcode version %d, want %d", opcodeVersion, code.Version)
+	}
+
+	numConstants := d.readUint32()
+	constants := []object.Object{}
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		constants = append(constants, d.readConstant())
+	}
+
+	instructions := code.Instructions(d.readBytes())
+	sourceMap := d.readSourceMap()
+	globalNames := d.readStrings()
+	handlers := d.readHandlers()
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("%d unexpected trailing bytes", len(d.data)-d.pos)
+	}
+
+	return &Bytecode{Instructions: instructions, Constants: constants, SourceMap: sourceMap, GlobalNames: globalNames, Handlers: handlers}, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) writeUint16(n int) {
+	binary.Write(&e.buf, binary.BigEndian, uint16(n))
+}
+
+func (e *encoder) writeUint32(n int) {
+	binary.Write(&e.buf, binary.BigEndian, uint32(n))
+}
+
+func (e *encoder) writeBytes(b []byte) {
+	e.writeUint32(len(b))
+	e.buf.Write(b)
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeBytes([]byte(s))
+}
+
+func (e *encoder) writeStrings(strs []string) {
+	e.writeUint32(len(strs))
+	for _, s := range strs {
+		e.writeString(s)
+	}
+}
+
+func (e *encoder) writeConstant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(integerTag)
+		binary.Write(&e.buf, binary.BigEndian, obj.Value)
+
+	case *object.BigInteger:
+		e.buf.WriteByte(bigIntegerTag)
+		sign := byte(0)
+		if obj.Value.Sign() < 0 {
+			sign = 1
+		}
+		e.buf.WriteByte(sign)
+		e.writeBytes(obj.Value.Bytes())
+
+	case *object.Float:
+		e.buf.WriteByte(floatTag)
+		binary.Write(&e.buf, binary.BigEndian, math.Float64bits(obj.Value))
+
+	case *object.String:
+		e.buf.WriteByte(stringTag)
+		e.writeString(obj.Value)
+
+	case *object.CompiledFunction:
+		e.buf.WriteByte(compiledFunctionTag)
+		e.writeString(obj.Name)
+		e.writeUint32(obj.NumLocals)
+		e.writeUint32(obj.NumParameters)
+		e.writeBytes(obj.Instructions)
+		e.writeSourceMap(obj.SourceMap)
+		e.writeStrings(obj.LocalNames)
+		e.writeStrings(obj.FreeNames)
+		e.writeHandlers(obj.Handlers)
+
+	default:
+		return fmt.Errorf("cannot marshal constant of type %s", obj.Type())
+	}
+
+	return nil
+}
+
+func (e *encoder) writeSourceMap(sourceMap code.SourceMap) {
+	e.writeUint32(len(sourceMap))
+	for _, entry := range sourceMap {
+		e.writeUint32(entry.Offset)
+		e.writeString(entry.Pos.File)
+		e.writeUint32(entry.Pos.Line)
+		e.writeUint32(entry.Pos.Column)
+		e.writeUint32(entry.Pos.Offset)
+	}
+}
+
+func (e *encoder) writeHandlers(handlers code.HandlerTable) {
+	e.writeUint32(len(handlers))
+	for _, h := range handlers {
+		e.writeUint32(h.Start)
+		e.writeUint32(h.End)
+		e.writeUint32(h.Handler)
+		e.writeUint32(h.StackDepth)
+	}
+}
+
+// Reads are sticky on error, ie, once a read fails every following read returns zero values and the first error is kept
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || len(d.data)-d.pos < n {
+		d.err = fmt.Errorf("unexpected end of bytecode at byte %d", d.pos)
+		return nil
+	}
+
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) readUint8() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) readUint16() int {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) readUint32() int {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > math.MaxInt32 { // Sizes and counts never get this large, so the data is corrupt
+		d.err = fmt.Errorf("value %d out of range at byte %d", n, d.pos-4)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) readBytes() []byte {
+	n := d.readUint32()
+	b := d.next(n)
+
+	// Copy so that the result does not keep the whole file alive
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
+
+func (d *decoder) readString() string {
+	return string(d.readBytes())
+}
+
+func (d *decoder) readStrings() []string {
+	n := d.readUint32()
+
+	var strs []string // Stays nil when empty, like the names the compiler produces
+	for i := 0; i < n && d.err == nil; i++ {
+		strs = append(strs, d.readString())
+	}
+	return strs
+}
+
+func (d *decoder) readConstant() object.Object {
+	tag := d.readUint8()
+	if d.err != nil {
+		return nil
+	}
+
+	switch tag {
+	case integerTag:
+		b := d.next(8)
+		if b == nil {
+			return nil
+		}
+		return &object.Integer{Value: int64(binary.BigEndian.Uint64(b))}
+
+	case bigIntegerTag:
+		negative := d.readUint8() == 1
+		value := new(big.Int).SetBytes(d.readBytes())
+		if negative {
+			value.Neg(value)
+		}
+		return object.NewInteger(value)
+
+	case floatTag:
+		b := d.next(8)
+		if b == nil {
+			return nil
+		}
+		return &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(b))}
+
+	case stringTag:
+		return &object.String{Value: d.readString()}
+
+	case compiledFunctionTag:
+		fn := &object.CompiledFunction{}
+		fn.Name = d.readString()
+		fn.NumLocals = d.readUint32()
+		fn.NumParameters = d.readUint32()
+		fn.Instructions = d.readBytes()
+		fn.SourceMap = d.readSourceMap()
+		fn.LocalNames = d.readStrings()
+		fn.FreeNames = d.readStrings()
+		fn.Handlers = d.readHandlers()
+		return fn
+
+	default:
+		d.err = fmt.Errorf("unknown constant tag %d at byte %d", tag, d.pos-1)
+		return nil
+	}
+}
+
+func (d *decoder) readSourceMap() code.SourceMap {
+	n := d.readUint32()
+
+	sourceMap := code.SourceMap{}
+	for i := 0; i < n && d.err == nil; i++ {
+		entry := code.SourceMapEntry{}
+		entry.Offset = d.readUint32()
+		entry.Pos = token.Position{
+			File:   d.readString(),
+			Line:   d.readUint32(),
+			Column: d.readUint32(),
+			Offset: d.readUint32(),
+		}
+		sourceMap = append(sourceMap, entry)
+	}
+
+	if len(sourceMap) == 0 {
+		return nil // Same as what the compiler produces for code without positions
+	}
+	return sourceMap
+}
+
+func (d *decoder) readHandlers() code.HandlerTable {
+	n := d.readUint32()
+
+	var handlers code.HandlerTable // Stays nil when empty, like the handlers the compiler produces
+	for i := 0; i < n && d.err == nil; i++ {
+		handlers = append(handlers, code.Handler{
+			Start:      d.readUint32(),
+			End:        d.readUint32(),
+			Handler:    d.readUint32(),
+			StackDepth: d.readUint32(),
+		})
+	}
+	return handlers
+}
@@ -0,0 +1,130 @@
+package compiler
+
+import (
+	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/object"
+	"encoding/binary"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testMarshalRoundTrip(t *testing.T, bytecode *Bytecode) {
+	t.Helper()
+
+	data, err := Marshal(bytecode)
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+
+	loaded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(bytecode, loaded) {
+		t.Fatalf("bytecode changed after round trip.\nwant=%#v\ngot=%#v", bytecode, loaded)
+	}
+}
+
+func TestMarshalHeader(t *testing.T) {
+	data, err := Marshal(compile(t, "1 + 2"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+
+	if string(data[:4]) != "\x7fMBC" {
+		t.Errorf("wrong magic. got=%q", data[:4])
+	}
+	if v := binary.BigEndian.Uint16(data[4:]); v != FormatVersion {
+		t.Errorf("wrong format version. got=%d, want=%d", v, FormatVersion)
+	}
+	if v := binary.BigEndian.Uint16(data[6:]); v != code.Version {
+		t.Errorf("wrong opcode version. got=%d, want=%d", v, code.Version)
+	}
+}
+
+func TestMarshalBigIntegers(t *testing.T) {
+	positive := object.NewInteger(new(big.Int).Lsh(big.NewInt(1), 100))
+	negative := object.NegateInteger(positive)
+
+	testMarshalRoundTrip(t, &Bytecode{Instructions: code.Instructions{}, Constants: []object.Object{positive, negative}})
+}
+
+func TestMarshalUnsupportedConstant(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Boolean{Value: true}}}
+
+	_, err := Marshal(bytecode)
+	if err == nil {
+		t.Fatalf("expected an error, got none")
+	}
+	if err.Error() != "constant 0: cannot marshal constant of type BOOLEAN" {
+		t.Errorf("wrong error. got=%q", err)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	valid, err := Marshal(compile(t, `let f = fn(a) { a + "x" }; f(1);`))
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+
+	withVersions := func(format, opcodes uint16) []byte {
+		data := append([]byte{}, valid...)
+		binary.BigEndian.PutUint16(data[4:], format)
+		binary.BigEndian.PutUint16(data[6:], opcodes)
+		return data
+	}
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{nil, "bad magic header"},
+		{[]byte("MONKEY"), "bad magic header"},
+		{withVersions(FormatVersion+1, code.Version), "unsupported bytecode format version"},
+		{withVersions(FormatVersion, code.Version+1), "bytecode was compiled for opcode version"},
+		{valid[:6], "unexpected end of bytecode"},
+		{valid[:len(valid)-1], "unexpected end of bytecode"},
+		{append(append([]byte{}, valid...), 0), "unexpected trailing bytes"},
+		{append(append([]byte{}, valid[:8]...), 0, 0, 0, 1, 0xff), "unknown constant tag 255"},
+		{append(append([]byte{}, valid[:8]...), 0xff, 0xff, 0xff, 0xff), "out of range"},
+	}
+
+	for i, tt := range tests {
+		_, err := Unmarshal(tt.data)
+		if err == nil {
+			t.Errorf("test %d: expected an error, got none", i)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("test %d: wrong error. want substring %q, got=%q", i, tt.expected, err)
+		}
+	}
+}
+
+func TestUnmarshalTruncatedNeverPanics(t *testing.T) {
+	data, err := Marshal(compile(t, `let f = fn(a, b) { let c = a; [c, b, "s"] }; f(1, 2)[0];`))
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		_, err := Unmarshal(data[:i])
+		if err == nil {
+			t.Errorf("expected an error for %d of %d bytes, got none", i, len(data))
+		}
+	}
+}
+
+func compile(t *testing.T, input string) *Bytecode {
+	t.Helper()
+
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return compiler.Bytecode()
+}
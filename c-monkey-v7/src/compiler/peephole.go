@@ -0,0 +1,317 @@
+package compiler
+
+import (
+	"Compiler/c-monkey-v7/src/code"
+	"sort"
+)
+
+/*
+The peephole optimizer cleans up the bytecode of a scope once it is complete, ie, a function body once it has been
+compiled or the main program when its Bytecode is taken. The compiler emits each construct on its own, so the seams
+between them are left with instructions that do nothing useful:
+
+	OpJump a; ... a: OpJump b           a jump to a jump goes straight to b instead
+	OpTrue; OpJumpNotTruthy a           never jumps, both are dropped (while (true) after folding)
+	OpFalse; OpJumpNotTruthy a          always jumps, becomes OpJump a
+	OpReturnValue; ...                  anything that can't be reached is dropped, ie, the OpJump over the else
+	                                    branch after an if branch that returns
+	OpJump a; a:                        a jump to the next instruction is dropped
+	OpSetLocal x; OpGetLocal x          becomes OpSetLocalKeep x, an assignment used as a value
+	OpSetLocalKeep x; OpPop             becomes OpSetLocal x, an assignment used as a statement
+
+Instructions are only merged or dropped when nothing jumps between them, and the rules are applied until none of them
+changes anything, as one rewrite often opens up another. The jump targets, the source map and the exception handlers
+are then moved to where their instructions ended up.
+*/
+func optimizeInstructions(ins code.Instructions, sourceMap code.SourceMap,
+	handlers code.HandlerTable) (code.Instructions, code.SourceMap, code.HandlerTable) {
+	p, ok := newPeephole(ins, handlers)
+	if !ok { // Malformed instructions are left for the VM to report
+		return ins, sourceMap, handlers
+	}
+
+	optimized := false
+	for {
+		changed := p.threadJumps()
+		changed = p.foldConstantJumps() || changed
+		changed = p.mergeLocals() || changed
+		changed = p.removeUnreachable() || changed
+		changed = p.removeJumpsToNext() || changed
+		if !changed {
+			break
+		}
+		optimized = true
+	}
+
+	if !optimized {
+		return ins, sourceMap, handlers
+	}
+
+	return p.assemble(), p.remapSourceMap(sourceMap), p.remapHandlers()
+}
+
+type peepholeInstruction struct {
+	offset   int // In the instructions as they were compiled
+	op       code.Opcode
+	operands []int // The target of a jump stays an offset into the instructions as they were compiled
+	removed  bool
+}
+
+type peephole struct {
+	instructions []*peepholeInstruction
+	handlers     code.HandlerTable
+	newOffsets   []int // Offset of each instruction once assembled, one past the end for the end of the instructions
+}
+
+// ok is false if the instructions can't be decoded
+func newPeephole(ins code.Instructions, handlers code.HandlerTable) (*peephole, bool) {
+	p := &peephole{handlers: handlers}
+
+	for offset := 0; offset < len(ins); {
+		_, operands, width, err := code.ReadInstruction(ins, offset)
+		if err != nil {
+			return nil, false
+		}
+		p.instructions = append(p.instructions, &peepholeInstruction{offset: offset, op: code.Opcode(ins[offset]), operands: operands})
+		offset += width
+	}
+
+	return p, true
+}
+
+func isJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop, code.OpIterNext:
+		return true
+	}
+	return false
+}
+
+// Instructions that never carry on to the next one
+func isTerminator(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpReturnValue, code.OpReturn, code.OpThrow:
+		return true
+	}
+	return false
+}
+
+// Index of the instruction that runs when execution reaches offset, ie, the first one at or after it that is still
+// there. len(p.instructions) for the end of the instructions
+func (p *peephole) resolve(offset int) int {
+	i := sort.Search(len(p.instructions), func(i int) bool { return p.instructions[i].offset >= offset })
+	return p.next(i - 1)
+}
+
+// Index of the first instruction after i that is still there
+func (p *peephole) next(i int) int {
+	for i++; i < len(p.instructions) && p.instructions[i].removed; i++ {
+	}
+	return i
+}
+
+// Instructions that can be reached other than by falling into them: jump targets and the boundaries of the protected
+// ranges. Nothing before one of them can be merged with it
+func (p *peephole) labels() map[int]bool {
+	labels := make(map[int]bool)
+	for _, in := range p.instructions {
+		if !in.removed && isJump(in.op) {
+			labels[p.resolve(in.operands[0])] = true
+		}
+	}
+	for _, h := range p.handlers {
+		labels[p.resolve(h.Start)] = true
+		labels[p.resolve(h.End)] = true
+		labels[p.resolve(h.Handler)] = true
+	}
+	return labels
+}
+
+// Calls fn with every instruction still there and the one after it, if that one is not a label
+func (p *peephole) forEachPair(fn func(first, second *peepholeInstruction) bool) bool {
+	labels := p.labels()
+	changed := false
+
+	for i := p.next(-1); i < len(p.instructions); i = p.next(i) {
+		j := p.next(i)
+		if j < len(p.instructions) && !labels[j] && fn(p.instructions[i], p.instructions[j]) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *peephole) threadJumps() bool {
+	changed := false
+
+	for _, in := range p.instructions {
+		if in.removed || !isJump(in.op) {
+			continue
+		}
+		if target := p.finalTarget(in.op, in.operands[0]); p.resolve(target) != p.resolve(in.operands[0]) {
+			in.operands[0] = target
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Where a jump to offset ends up. An OpJump passes any jump on, and a short circuit jump also passes on the same kind
+// of jump since the value it keeps decides that one too. A loop of jumps ends where it first comes back around
+func (p *peephole) finalTarget(op code.Opcode, offset int) int {
+	visited := make(map[int]bool)
+
+	for {
+		i := p.resolve(offset)
+		if i == len(p.instructions) || visited[i] {
+			return offset
+		}
+		visited[i] = true
+
+		target := p.instructions[i]
+		passesOn := target.op == code.OpJump ||
+			(target.op == op && (op == code.OpJumpNotTruthyOrPop || op == code.OpJumpTruthyOrPop))
+		if !passesOn {
+			return offset
+		}
+		offset = target.operands[0]
+	}
+}
+
+func (p *peephole) foldConstantJumps() bool {
+	return p.forEachPair(func(first, second *peepholeInstruction) bool {
+		if second.op != code.OpJumpNotTruthy {
+			return false
+		}
+
+		switch first.op {
+		case code.OpTrue:
+			first.removed = true
+			second.removed = true
+		case code.OpFalse:
+			first.op, first.operands = code.OpJump, second.operands
+			second.removed = true
+		default:
+			return false
+		}
+		return true
+	})
+}
+
+func (p *peephole) mergeLocals() bool {
+	return p.forEachPair(func(first, second *peepholeInstruction) bool {
+		switch {
+		case first.op == code.OpSetLocal && second.op == code.OpGetLocal && first.operands[0] == second.operands[0]:
+			first.op = code.OpSetLocalKeep
+		case first.op == code.OpSetLocalKeep && second.op == code.OpPop:
+			first.op = code.OpSetLocal
+		default:
+			return false
+		}
+
+		second.removed = true
+		return true
+	})
+}
+
+// Removes the instructions that no path from the start, or from an exception handler, leads to
+func (p *peephole) removeUnreachable() bool {
+	reached := make([]bool, len(p.instructions))
+	pending := []int{p.next(-1)}
+	for _, h := range p.handlers {
+		pending = append(pending, p.resolve(h.Handler))
+	}
+
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if i == len(p.instructions) || reached[i] {
+			continue
+		}
+		reached[i] = true
+
+		in := p.instructions[i]
+		if isJump(in.op) {
+			pending = append(pending, p.resolve(in.operands[0]))
+		}
+		if !isTerminator(in.op) {
+			pending = append(pending, p.next(i))
+		}
+	}
+
+	changed := false
+	for i, in := range p.instructions {
+		if !in.removed && !reached[i] {
+			in.removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *peephole) removeJumpsToNext() bool {
+	changed := false
+
+	for i := p.next(-1); i < len(p.instructions); i = p.next(i) {
+		in := p.instructions[i]
+		if in.op == code.OpJump && p.resolve(in.operands[0]) == p.next(i) {
+			in.removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *peephole) assemble() code.Instructions {
+	p.newOffsets = make([]int, len(p.instructions)+1)
+	offset := 0
+	for i, in := range p.instructions {
+		p.newOffsets[i] = offset
+		if !in.removed {
+			offset += len(code.Make(in.op, in.operands...))
+		}
+	}
+	p.newOffsets[len(p.instructions)] = offset
+
+	ins := make(code.Instructions, 0, offset)
+	for _, in := range p.instructions {
+		if in.removed {
+			continue
+		}
+		if isJump(in.op) {
+			in.operands[0] = p.newOffset(in.operands[0])
+		}
+		ins = append(ins, code.Make(in.op, in.operands...)...)
+	}
+	return ins
+}
+
+// Where the instruction that ran at offset before the rewrite ends up, or the one that now runs in its place
+func (p *peephole) newOffset(offset int) int {
+	return p.newOffsets[p.resolve(offset)]
+}
+
+func (p *peephole) remapSourceMap(sourceMap code.SourceMap) code.SourceMap {
+	end := p.newOffsets[len(p.instructions)]
+
+	var remapped code.SourceMap
+	for _, e := range sourceMap {
+		e.Offset = p.newOffset(e.Offset)
+		if n := len(remapped); n > 0 && remapped[n-1].Offset == e.Offset { // Everything it covered was removed
+			remapped = remapped[:n-1]
+		}
+		if n := len(remapped); e.Offset < end && (n == 0 || remapped[n-1].Pos != e.Pos) {
+			remapped = append(remapped, e)
+		}
+	}
+	return remapped
+}
+
+func (p *peephole) remapHandlers() code.HandlerTable {
+	var remapped code.HandlerTable
+	for _, h := range p.handlers {
+		h.Start, h.End, h.Handler = p.newOffset(h.Start), p.newOffset(h.End), p.newOffset(h.Handler)
+		remapped = append(remapped, h)
+	}
+	return remapped
+}
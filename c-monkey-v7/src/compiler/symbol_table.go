@@ -3,8 +3,11 @@ package compiler
 type SymbolScope string
 
 const (
-	GlobalScope SymbolScope = "GLOBAL"
-	LocalScope  SymbolScope = "LOCAL"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	FreeScope     SymbolScope = "FREE"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -18,11 +21,15 @@ type SymbolTable struct {
 
 	store          map[string]Symbol // Strings are identifiers
 	numDefinitions int               // To keep track of number of definitions in the store, ie, Index
+	names          []string          // Name of every defined slot by Index
+
+	FreeSymbols []Symbol // The original symbols of the enclosing scope that this scope captures, in order of capture
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
-	return &SymbolTable{store: s}
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
 }
 
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
@@ -31,7 +38,13 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// Defining a name this table already binds rebinds its slot, the way the evaluator overwrites a name in its environment.
+// A let in a loop body then updates the variable on every pass, and the code after the loop sees the last value
 func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
@@ -39,15 +52,68 @@ func (s *SymbolTable) Define(name string) Symbol {
 		symbol.Scope = LocalScope
 	}
 	s.store[name] = symbol
+	s.names = append(s.names, name)
 	s.numDefinitions++
 	return symbol
 }
 
+// Names of the global or local slots defined in this table, indexed by slot. Used for debug info like disassembly
+func (s *SymbolTable) SlotNames() []string {
+	return append([]string(nil), s.names...)
+}
+
+// Builtins are defined with the index they have in object.Builtins, so the VM can look them up directly
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// The name of the function being compiled resolves to the closure that is currently executing, so self reference
+// never has to be captured as a free variable. It does not count as a local binding
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// Keep track of the original symbol in FreeSymbols and store a FreeScope copy of it pointing to its index in FreeSymbols
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// The symbol a free variable was first captured from, along with the table that defines it
+func (s *SymbolTable) capturedSymbol(symbol Symbol) (Symbol, *SymbolTable) {
+	table := s
+	for symbol.Scope == FreeScope {
+		symbol = table.FreeSymbols[symbol.Index]
+		table = table.Outer
+	}
+	return symbol, table
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	if !ok && s.Outer != nil {
 		obj, ok = s.Outer.Resolve(name)
-		return obj, ok
+		if !ok {
+			return obj, ok
+		}
+
+		// Globals and builtins are reachable from anywhere, so they never need to be captured
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+
+		// Anything local (or already free) to an enclosing scope has to be captured as a free variable of this scope
+		free := s.defineFree(obj)
+		return free, true
 	}
 	return obj, ok
 }
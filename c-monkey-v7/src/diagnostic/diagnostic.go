@@ -0,0 +1,180 @@
+package diagnostic
+
+import (
+	"Compiler/c-monkey-v7/src/token"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Every kind of diagnostic has a stable code, so tooling can match on it instead of on the message text.
+// Parser diagnostics are E00xx and compiler diagnostics are E01xx
+type Code string
+
+const (
+	UnexpectedToken       Code = "E0001" // A specific token was expected next
+	NoPrefixParseFn       Code = "E0002" // The token cannot start an expression
+	InvalidIntegerLiteral Code = "E0003"
+	InvalidAssignTarget   Code = "E0004" // Only names and index expressions can be assigned to
+	InvalidFloatLiteral   Code = "E0005" // Out of the range of a float64
+	MissingHandler        Code = "E0006" // A try without a catch or a finally
+	UnterminatedString    Code = "E0007"
+	InvalidEscape         Code = "E0008" // Unknown escape sequence, or a bad code point in one
+	UnterminatedComment   Code = "E0009"
+
+	UndefinedVariable Code = "E0101"
+	UnknownOperator   Code = "E0102"
+	OutsideLoop       Code = "E0103" // break or continue that is not inside a loop
+	InvalidAssignment Code = "E0104" // Assigning to a builtin or to a function inside its own body
+	SyntaxError       Code = "E0105" // The AST still has bad nodes from parser errors
+)
+
+// The region of source a diagnostic points at. End is exclusive, and may be left as the zero Position to
+// point at a single char
+type Span struct {
+	Start token.Position
+	End   token.Position
+}
+
+// Span covering the whole token
+func TokenSpan(tok token.Token) Span {
+	length := len(tok.Literal)
+	if tok.Type == token.STRING { // The literal does not include the quotes
+		length += 2
+	}
+
+	end := tok.Pos
+	end.Column += length
+	end.Offset += length
+
+	return Span{Start: tok.Pos, End: end}
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Code     Code
+	Span     Span
+	Message  string
+	Notes    []string // Extra lines of context, rendered after the source snippet
+}
+
+func Errorf(code Code, span Span, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Error, Code: code, Span: span, Message: fmt.Sprintf(format, a...)}
+}
+
+// Diagnostics are errors so that they can be returned through the usual error values, ie, from compiler.Compile
+func (d *Diagnostic) Error() string {
+	if d.Span.Start.IsValid() {
+		return fmt.Sprintf("%s: %s", d.Span.Start, d.Message)
+	}
+	return d.Message
+}
+
+func (d *Diagnostic) String() string {
+	return d.Error()
+}
+
+/*
+Render writes the diagnostic the way rustc does, with the offending line of source underlined:
+
+	error[E0101]: undefined variable x
+	 --> 2:9
+	  |
+	2 | let y = x + 1;
+	  |         ^
+*/
+func Render(out io.Writer, source string, d *Diagnostic) {
+	fmt.Fprintf(out, "%s[%s]: %s\n", d.Severity, d.Code, d.Message)
+
+	start := d.Span.Start
+	line, ok := sourceLine(source, start.Line)
+	gutter := strings.Repeat(" ", len(fmt.Sprint(start.Line)))
+
+	if start.IsValid() {
+		fmt.Fprintf(out, "%s--> %s\n", gutter, start)
+	}
+
+	if ok && start.Column >= 1 && start.Column <= len(line)+1 {
+		fmt.Fprintf(out, "%s |\n", gutter)
+		fmt.Fprintf(out, "%d | %s\n", start.Line, line)
+		fmt.Fprintf(out, "%s | %s%s\n", gutter, padding(line, start.Column), strings.Repeat("^", underlineWidth(line, d.Span)))
+	}
+
+	for _, note := range d.Notes {
+		fmt.Fprintf(out, "%s = note: %s\n", gutter, note)
+	}
+}
+
+func sourceLine(source string, line int) (string, bool) {
+	if line < 1 {
+		return "", false
+	}
+
+	lines := strings.Split(source, "\n")
+	if line > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimRight(lines[line-1], "\r"), true
+}
+
+// Whitespace up to the given column. Tabs are kept as tabs so that the caret lines up with the source, and a
+// multibyte char takes a single space even though columns count bytes
+func padding(line string, column int) string {
+	var out strings.Builder
+
+	for _, ch := range line[:min(column-1, len(line))] {
+		if ch == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+
+	return out.String()
+}
+
+func underlineWidth(line string, span Span) int {
+	start := span.Start
+	end := span.End
+
+	width := 1
+	switch {
+	case end.Line == start.Line && end.Column > start.Column:
+		width = end.Column - start.Column
+	case end.Line > start.Line: // Spans onto the next lines, so underline up to the end of this one
+		width = len(line) - start.Column + 1
+	}
+
+	// Never run past the end of the line, but always show at least one caret
+	if start.Column+width-1 > len(line) {
+		width = len(line) - start.Column + 1
+	}
+	if width < 1 {
+		return 1
+	}
+
+	return max(utf8.RuneCountInString(line[start.Column-1:start.Column-1+width]), 1) // One caret per char, not per byte
+}
@@ -0,0 +1,134 @@
+package diagnostic
+
+import (
+	"Compiler/c-monkey-v7/src/token"
+	"bytes"
+	"testing"
+)
+
+func TestTokenSpan(t *testing.T) {
+	tests := []struct {
+		tok         token.Token
+		expectedEnd token.Position
+	}{
+		{
+			token.Token{Type: token.IDENT, Literal: "foobar", Pos: token.Position{Line: 2, Column: 3, Offset: 10}},
+			token.Position{Line: 2, Column: 9, Offset: 16},
+		},
+		{
+			token.Token{Type: token.STRING, Literal: "ab", Pos: token.Position{Line: 1, Column: 1, Offset: 0}},
+			token.Position{Line: 1, Column: 5, Offset: 4},
+		},
+		{
+			token.Token{Type: token.EOF, Literal: "", Pos: token.Position{Line: 1, Column: 7, Offset: 6}},
+			token.Position{Line: 1, Column: 7, Offset: 6},
+		},
+	}
+
+	for _, tt := range tests {
+		span := TokenSpan(tt.tok)
+		if span.Start != tt.tok.Pos {
+			t.Errorf("span start wrong. want=%+v, got=%+v", tt.tok.Pos, span.Start)
+		}
+		if span.End != tt.expectedEnd {
+			t.Errorf("span end wrong. want=%+v, got=%+v", tt.expectedEnd, span.End)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	span := Span{Start: token.Position{File: "main.mk", Line: 3, Column: 4}}
+	d := Errorf(UndefinedVariable, span, "undefined variable %s", "x")
+
+	if d.Error() != "main.mk:3:4: undefined variable x" {
+		t.Errorf("wrong error string. got=%q", d.Error())
+	}
+
+	d = Errorf(UndefinedVariable, Span{}, "undefined variable %s", "x")
+	if d.Error() != "undefined variable x" {
+		t.Errorf("wrong error string without position. got=%q", d.Error())
+	}
+}
+
+func TestRender(t *testing.T) {
+	source := "let x = 1;\nlet y = x + z;\n\tlet w = foo;\nlet café = naïf;\n"
+
+	tests := []struct {
+		diagnostic *Diagnostic
+		expected   string
+	}{
+		{
+			&Diagnostic{
+				Severity: Error,
+				Code:     UndefinedVariable,
+				Span: Span{
+					Start: token.Position{Line: 2, Column: 13, Offset: 23},
+					End:   token.Position{Line: 2, Column: 14, Offset: 24},
+				},
+				Message: "undefined variable z",
+			},
+			"error[E0101]: undefined variable z\n" +
+				" --> 2:13\n" +
+				"  |\n" +
+				"2 | let y = x + z;\n" +
+				"  |             ^\n",
+		},
+		{
+			&Diagnostic{
+				Severity: Warning,
+				Code:     UndefinedVariable,
+				Span: Span{
+					Start: token.Position{File: "a.mk", Line: 3, Column: 10},
+					End:   token.Position{File: "a.mk", Line: 3, Column: 13},
+				},
+				Message: "undefined variable foo",
+				Notes:   []string{"tabs are kept so the underline lines up"},
+			},
+			"warning[E0101]: undefined variable foo\n" +
+				" --> a.mk:3:10\n" +
+				"  |\n" +
+				"3 | \tlet w = foo;\n" +
+				"  | \t        ^^^\n" +
+				"  = note: tabs are kept so the underline lines up\n",
+		},
+		{
+			&Diagnostic{
+				Severity: Error,
+				Code:     UnexpectedToken,
+				Span:     Span{Start: token.Position{Line: 1, Column: 5}, End: token.Position{Line: 2, Column: 1}},
+				Message:  "spans to the next line",
+			},
+			"error[E0001]: spans to the next line\n" +
+				" --> 1:5\n" +
+				"  |\n" +
+				"1 | let x = 1;\n" +
+				"  |     ^^^^^^\n",
+		},
+		{
+			&Diagnostic{
+				Severity: Error,
+				Code:     UndefinedVariable,
+				Span:     Span{Start: token.Position{Line: 4, Column: 13}, End: token.Position{Line: 4, Column: 18}},
+				Message:  "columns count bytes, carets count chars",
+			},
+			"error[E0101]: columns count bytes, carets count chars\n" +
+				" --> 4:13\n" +
+				"  |\n" +
+				"4 | let café = naïf;\n" +
+				"  |            ^^^^\n",
+		},
+		{
+			&Diagnostic{Severity: Error, Code: UnknownOperator, Message: "no position"},
+			"error[E0102]: no position\n",
+		},
+	}
+
+	for i, tt := range tests {
+		var out bytes.Buffer
+		Render(&out, source, tt.diagnostic)
+
+		if out.String() != tt.expected {
+			t.Errorf("test[%d] - wrong rendering.\nwant=\n%s\ngot=\n%s", i, tt.expected, out.String())
+		}
+	}
+}
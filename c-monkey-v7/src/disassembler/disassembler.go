@@ -0,0 +1,165 @@
+package disassembler
+
+import (
+	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/compiler"
+	"Compiler/c-monkey-v7/src/object"
+	"bytes"
+	"fmt"
+)
+
+const annotationColumn = 24 // Instructions are padded to this width before the `; annotation` starts
+
+// Disassemble lists the main instructions followed by every compiled function in the constant pool. Operands are
+// annotated with what they refer to, ie, constant values, jump targets, and the names of globals, locals, free
+// variables and builtins. Malformed bytecode is reported inline instead of stopping the listing
+func Disassemble(bytecode *compiler.Bytecode) string {
+	var out bytes.Buffer
+
+	main := &object.CompiledFunction{Instructions: bytecode.Instructions, Handlers: bytecode.Handlers}
+	d := &disassembler{out: &out, bytecode: bytecode}
+
+	out.WriteString("== main ==\n")
+	d.function(main)
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&out, "\n== constant %d: %s (%s, %s, %s) ==\n", i, functionName(fn),
+			plural(fn.NumParameters, "parameter"), plural(fn.NumLocals, "local"), plural(len(fn.FreeNames), "free variable"))
+		d.function(fn)
+	}
+
+	return out.String()
+}
+
+type disassembler struct {
+	out      *bytes.Buffer
+	bytecode *compiler.Bytecode
+}
+
+func (d *disassembler) function(fn *object.CompiledFunction) {
+	ins := fn.Instructions
+
+	// First pass to find where instructions start, so jumps into the middle of one can be flagged
+	starts := make(map[int]bool)
+	for i := 0; i < len(ins); {
+		_, _, width, _ := code.ReadInstruction(ins, i)
+		starts[i] = true
+		i += width
+	}
+
+	for i := 0; i < len(ins); {
+		def, operands, width, err := code.ReadInstruction(ins, i)
+		if err != nil {
+			fmt.Fprintf(d.out, "%04d ERROR: %s\n", i, err)
+			i += width
+			continue
+		}
+
+		line := def.Name
+		for _, operand := range operands {
+			line += fmt.Sprintf(" %d", operand)
+		}
+
+		annotation := d.annotate(fn, code.Opcode(ins[i]), operands, len(ins), starts)
+		if annotation == "" {
+			fmt.Fprintf(d.out, "%04d %s\n", i, line)
+		} else {
+			fmt.Fprintf(d.out, "%04d %-*s ; %s\n", i, annotationColumn, line, annotation)
+		}
+
+		i += width
+	}
+
+	for _, h := range fn.Handlers {
+		fmt.Fprintf(d.out, "     try %04d-%04d -> %04d, stack depth %d\n", h.Start, h.End, h.Handler, h.StackDepth)
+	}
+}
+
+func (d *disassembler) annotate(fn *object.CompiledFunction, op code.Opcode, operands []int, length int, starts map[int]bool) string {
+	switch op {
+	case code.OpConstant:
+		return d.constant(operands[0])
+
+	case code.OpClosure:
+		return fmt.Sprintf("%s, %s", d.constant(operands[0]), plural(operands[1], "free variable"))
+
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return slotName(d.bytecode.GlobalNames, operands[0])
+
+	case code.OpGetLocal, code.OpSetLocal, code.OpSetLocalKeep, code.OpCaptureLocal:
+		return slotName(fn.LocalNames, operands[0])
+
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		return slotName(fn.FreeNames, operands[0])
+
+	case code.OpGetBuiltin:
+		if operands[0] >= len(object.Builtins) {
+			return fmt.Sprintf("invalid builtin %d", operands[0])
+		}
+		return object.Builtins[operands[0]].Name
+
+	case code.OpCurrentClosure:
+		return functionName(fn)
+
+	case code.OpCompoundSetIndex:
+		def, err := code.Lookup(byte(operands[0]))
+		if err != nil {
+			return fmt.Sprintf("invalid operator %d", operands[0])
+		}
+		return def.Name
+
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop, code.OpIterNext:
+		target := operands[0]
+		switch {
+		case target > length:
+			return fmt.Sprintf("-> %04d, past the end", target)
+		case target < length && !starts[target]:
+			return fmt.Sprintf("-> %04d, not an instruction", target)
+		}
+		return fmt.Sprintf("-> %04d", target)
+	}
+
+	return ""
+}
+
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.bytecode.Constants) {
+		return fmt.Sprintf("invalid constant %d", index)
+	}
+
+	switch constant := d.bytecode.Constants[index].(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		return functionName(constant)
+	default:
+		return constant.Inspect()
+	}
+}
+
+// Names are only debug info, so bytecode without them (or with too few) still disassembles, just without annotations
+func slotName(names []string, index int) string {
+	if index < len(names) {
+		return names[index]
+	}
+	return ""
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "fn <anonymous>"
+	}
+	return "fn " + fn.Name
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
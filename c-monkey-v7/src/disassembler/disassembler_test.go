@@ -0,0 +1,173 @@
+package disassembler
+
+import (
+	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/compiler"
+	"Compiler/c-monkey-v7/src/lexer"
+	"Compiler/c-monkey-v7/src/object"
+	"Compiler/c-monkey-v7/src/parser"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `
+	let total = 0;
+	let add = fn(a) { let b = a; fn(c) { if (c) { len(b) } else { add(total) } } };
+	add("x")(true);
+	`
+
+	// add is hoisted, so it gets global slot 0 before total
+	expected := `== main ==
+0000 OpConstant 0             ; 0
+0003 OpSetGlobal 0            ; total
+0006 OpClosure 2 0            ; fn add, 0 free variables
+0010 OpSetGlobal 1            ; add
+0013 OpGetGlobal 1            ; add
+0016 OpConstant 3             ; "x"
+0019 OpCall 1
+0021 OpTrue
+0022 OpCall 1
+0024 OpPop
+
+== constant 1: fn <anonymous> (1 parameter, 1 local, 2 free variables) ==
+0000 OpGetLocal 0             ; c
+0002 OpJumpNotTruthy 14       ; -> 0014
+0005 OpGetBuiltin 0           ; len
+0007 OpGetFree 0              ; b
+0009 OpCall 1
+0011 OpJump 21                ; -> 0021
+0014 OpGetFree 1              ; add
+0016 OpGetGlobal 0            ; total
+0019 OpCall 1
+0021 OpReturnValue
+
+== constant 2: fn add (1 parameter, 2 locals, 0 free variables) ==
+0000 OpGetLocal 0             ; a
+0002 OpSetLocal 1             ; b
+0004 OpCaptureLocal 1         ; b
+0006 OpCurrentClosure         ; fn add
+0007 OpClosure 1 2            ; fn <anonymous>, 2 free variables
+0011 OpReturnValue
+`
+
+	actual := Disassemble(compile(t, input))
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestDisassembleForLoop(t *testing.T) {
+	expected := `== main ==
+0000 OpArray 0
+0003 OpIter
+0004 OpIterNext 17 2          ; -> 0017
+0008 OpSetGlobal 1            ; x
+0011 OpSetGlobal 0            ; i
+0014 OpJump 4                 ; -> 0004
+`
+
+	actual := Disassemble(compile(t, "for (i, x in []) { }"))
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestDisassembleTry(t *testing.T) {
+	expected := `== main ==
+0000 OpConstant 0             ; 1
+0003 OpThrow
+0004 OpJump 13                ; -> 0013
+0007 OpSetGlobal 0            ; e
+0010 OpJump 13                ; -> 0013
+     try 0000-0004 -> 0007, stack depth 0
+`
+
+	actual := Disassemble(compile(t, "try { throw 1 } catch (e) { }"))
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestDisassembleKeepsNamesThroughMarshal(t *testing.T) {
+	bytecode := compile(t, `let x = 1; let f = fn(y) { x + y }; f(x);`)
+
+	data, err := compiler.Marshal(bytecode)
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	loaded, err := compiler.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %s", err)
+	}
+
+	if Disassemble(loaded) != Disassemble(bytecode) {
+		t.Errorf("disassembly changed after round trip.\nwant=\n%s\ngot=\n%s", Disassemble(bytecode), Disassemble(loaded))
+	}
+}
+
+func TestDisassembleMalformed(t *testing.T) {
+	fn := &object.CompiledFunction{
+		Instructions: concat(
+			code.Make(code.OpGetLocal, 3), // No local names at all
+			code.Make(code.OpJump, 4),     // Into the middle of OpJump itself
+			code.Make(code.OpJump, 99),
+			code.Make(code.OpGetBuiltin, 200),
+		),
+	}
+
+	bytecode := &compiler.Bytecode{
+		Instructions: concat(
+			code.Make(code.OpConstant, 7),
+			code.Make(code.OpClosure, 0, 0),
+			code.Make(code.OpGetGlobal, 1),
+			[]byte{255},
+			code.Make(code.OpConstant, 1)[:2],
+		),
+		Constants: []object.Object{fn},
+	}
+
+	expected := `== main ==
+0000 OpConstant 7             ; invalid constant 7
+0003 OpClosure 0 0            ; fn <anonymous>, 0 free variables
+0007 OpGetGlobal 1
+0010 ERROR: opcode 255 undefined
+0011 ERROR: truncated OpConstant, want 2 operand bytes, got 1
+
+== constant 0: fn <anonymous> (0 parameters, 0 locals, 0 free variables) ==
+0000 OpGetLocal 3
+0002 OpJump 4                 ; -> 0004, not an instruction
+0005 OpJump 99                ; -> 0099, past the end
+0008 OpGetBuiltin 200         ; invalid builtin 200
+`
+
+	actual := Disassemble(bytecode)
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := compiler.New()
+	c.DisableOptimizations() // The instructions as the compiler emits them, which the expected listings spell out
+	err := c.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return c.Bytecode()
+}
+
+func concat(instructions ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
@@ -3,13 +3,22 @@ package evaluator
 import (
 	"Compiler/c-monkey-v7/src/ast"
 	"Compiler/c-monkey-v7/src/object"
+	"Compiler/c-monkey-v7/src/token"
 	"fmt"
+	"math"
+	"strings"
 )
 
+// Deepest nesting of function calls, the same as the VM's MaxFrames
+const MaxCallDepth = 1024
+
 var (
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
 	NULL  = &object.Null{}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func nativeBooltoBooleanObject(input bool) *object.Boolean {
@@ -26,6 +35,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalBitNotPrefixOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -45,54 +56,118 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	if f, ok := right.(*object.Float); ok {
+		return &object.Float{Value: -f.Value}
+	}
+
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("unknown operator: -%s", right.Type())
 	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NegateInteger(right)
+}
+
+func evalBitNotPrefixOperatorExpression(right object.Object) object.Object {
+	if right.Type() != object.INTEGER_OBJ {
+		return newError("unknown operator: ~%s", right.Type())
+	}
+
+	return object.BitNotInteger(right)
 }
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right): // At least one is a float, so both are used as floats
+		return evalFloatInfixExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBooltoBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBooltoBooleanObject(left != right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// Strings compare by value, like in the VM
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBooltoBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBooltoBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+	switch operator {
+	case "<":
+		return nativeBooltoBooleanObject(object.CompareIntegers(left, right) < 0)
+	case ">":
+		return nativeBooltoBooleanObject(object.CompareIntegers(left, right) > 0)
+	case "==":
+		return nativeBooltoBooleanObject(object.CompareIntegers(left, right) == 0)
+	case "!=":
+		return nativeBooltoBooleanObject(object.CompareIntegers(left, right) != 0)
+	case "<=":
+		return nativeBooltoBooleanObject(object.CompareIntegers(left, right) <= 0)
+	case ">=":
+		return nativeBooltoBooleanObject(object.CompareIntegers(left, right) >= 0)
+	case "+", "-", "*", "/", "%", "**", "&", "|", "^", "<<", ">>":
+		result, err := object.IntegerOperation(operator, left, right)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return result
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// The right operand is only evaluated when the left one doesn't decide the result, which is whichever operand was
+// evaluated last rather than a boolean
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if isTruthy(left) == (node.Operator == "||") {
+		return left
+	}
+
+	return Eval(node.Right, env)
+}
+
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal, _ := object.ToFloat(left)
+	rightVal, _ := object.ToFloat(right)
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return &object.Float{Value: leftVal + rightVal}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return &object.Float{Value: leftVal - rightVal}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
 	case "<":
 		return nativeBooltoBooleanObject(leftVal < rightVal)
 	case ">":
@@ -110,6 +185,11 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	_, ok := object.ToFloat(obj)
+	return ok
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
 	if isError(condition) {
@@ -138,8 +218,13 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
+func evalProgram(program *ast.Program, env *object.Environment) (result object.Object) {
+	// A Go panic here would be a bug in the evaluator, but it shouldn't take down whatever is embedding it
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
 
 	for _, statement := range program.Statements {
 		result = Eval(statement, env)
@@ -149,6 +234,8 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return newError("%s outside of a loop", result.Inspect())
 		}
 	}
 
@@ -163,7 +250,7 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -172,6 +259,122 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			break
+		}
+
+		result := Eval(ws.Body, env)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.BREAK_OBJ {
+				break
+			}
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+			// CONTINUE only had to stop the rest of the body, so on to the condition
+		}
+	}
+
+	return NULL
+}
+
+// Errors travel up as *object.Error, so a try catches the one its body returns. The finally block runs however the
+// body and catch block finished, and only overrides how if it errors, returns or leaves a loop itself
+func evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	result := Eval(ts.Body, env)
+
+	if err, ok := result.(*object.Error); ok && ts.Catch != nil {
+		env.Set(ts.CatchName.Value, caughtValue(err))
+		result = Eval(ts.Catch, env)
+	}
+
+	if ts.Finally != nil {
+		finally := Eval(ts.Finally, env)
+		if isAbrupt(finally) {
+			return finally
+		}
+	}
+
+	if isAbrupt(result) {
+		return result
+	}
+	return NULL
+}
+
+// What a catch receives for err, the thrown value or, for an error of the evaluator or a builtin, an exception
+func caughtValue(err *object.Error) object.Object {
+	if err.Value != nil {
+		return err.Value
+	}
+
+	trace := err.Trace
+	if trace == nil {
+		trace = []string{}
+	}
+	return &object.Exception{Message: err.Message, Trace: trace}
+}
+
+// Whether obj stops the statements after it from running, ie, it is an error, a return or a break or continue
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return true
+	default:
+		return false
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	iterator, err := object.NewIterator(iterable)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	for {
+		// The names are bound in the enclosing environment, like let statements in the body would be
+		if len(fs.Names) == 1 {
+			element, ok := iterator.NextElement()
+			if !ok {
+				break
+			}
+			env.Set(fs.Names[0].Value, element)
+		} else {
+			key, value, ok := iterator.Next()
+			if !ok {
+				break
+			}
+			env.Set(fs.Names[0].Value, key)
+			env.Set(fs.Names[1].Value, value)
+		}
+
+		result := Eval(fs.Body, env)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.BREAK_OBJ {
+				break
+			}
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+
+	return NULL
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -217,6 +420,11 @@ func evalIndexExpression(left, index object.Object) object.Object {
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.EXCEPTION_OBJ:
+		if field := left.(*object.Exception).Field(index); field != nil {
+			return field
+		}
+		return NULL
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -240,14 +448,97 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+	i, ok := index.(*object.Integer) // Not ok for a big integer, which is out of range anyway
+	if !ok || i.Value < 0 || i.Value >= int64(len(arrayObject.Elements)) {
+		return newError("index out of range: %s, length %d", index.Inspect(), len(arrayObject.Elements))
+	}
 
-	if idx < 0 || idx > max {
-		return NULL
+	return arrayObject.Elements[i.Value]
+}
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		name := target.Value
+		owner, ok := env.Resolve(name)
+		if !ok {
+			if _, ok := builtins[name]; ok {
+				return newError("cannot assign to builtin %s", name)
+			}
+			return newError("cannot assign to undefined variable %s", name)
+		}
+		current, _ := owner.Get(name) // Read before the value, which may assign to the same name, like the compiled code does
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+
+		if node.Operator != "=" {
+			val = evalInfixExpression(strings.TrimSuffix(node.Operator, "="), current, val)
+			if isError(val) {
+				return val
+			}
+		}
+
+		owner.Set(name, val)
+		return val
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+
+		if node.Operator != "=" {
+			current := evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+			val = evalInfixExpression(strings.TrimSuffix(node.Operator, "="), current, val)
+			if isError(val) {
+				return val
+			}
+		}
+
+		return evalSetIndex(left, index, val)
+	}
+
+	return newError("cannot assign to %s", node.Target.String())
+}
+
+// Arrays and hashes are changed in place, so every reference to them sees the new element
+func evalSetIndex(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		if index.Type() != object.INTEGER_OBJ {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		i, ok := index.(*object.Integer) // Not ok for a big integer, which is out of range anyway
+		if !ok || i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %s, length %d", index.Inspect(), len(left.Elements))
+		}
+		left.Elements[i.Value] = val
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+
+	default:
+		return newError("index assignment not supported: %s", left.Type())
 	}
 
-	return arrayObject.Elements[idx]
+	return val
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
@@ -276,11 +567,22 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 	return &object.Hash{Pairs: pairs}
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// pos is where the call is made, for the trace of an error raised in fn
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment, pos token.Position) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		if env.Depth() >= MaxCallDepth {
+			return newError("stack overflow")
+		}
+		extendedEnv := extendFunctionEnv(fn, args, env, pos)
 		evaluated := Eval(fn.Body, extendedEnv)
+		switch evaluated.(type) {
+		case *object.Break, *object.Continue: // A loop can't be left from inside a function called in it
+			return newError("%s outside of a loop", evaluated.Inspect())
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
 		if result := fn.Fn(args...); result != nil {
@@ -293,8 +595,12 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+func extendFunctionEnv(fn *object.Function, args []object.Object, caller *object.Environment, pos token.Position) *object.Environment {
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	env := object.NewCallEnvironment(fn.Env, caller, name, pos)
 
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -312,6 +618,19 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return locate(eval(node, env), node, env)
+}
+
+// The first node an error of the evaluator or a builtin comes out of is the one that raised it, so that is where its
+// trace is taken, while every call it happened in is still running. A thrown value has no trace, like in the VM
+func locate(obj object.Object, node ast.Node, env *object.Environment) object.Object {
+	if err, ok := obj.(*object.Error); ok && err.Value == nil && err.Trace == nil {
+		err.Trace = env.Trace(ast.ErrorPosition(node))
+	}
+	return obj
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
@@ -331,11 +650,38 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
+		if val == BREAK || val == CONTINUE { // The value was an if that left the loop, so there is nothing to bind
+			return val
+		}
 		env.Set(node.Name.Value, val)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return &object.Error{Message: object.UncaughtMessage(val), Value: val}
+	case *ast.BadStatement:
+		return newError("cannot evaluate a statement that failed to parse")
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 
 	// Expressions
+	case *ast.BadExpression:
+		return newError("cannot evaluate an expression that failed to parse")
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.BigIntegerLiteral:
+		return object.NewInteger(node.Value)
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBooltoBooleanObject(node.Value)
 	case *ast.PrefixExpression:
@@ -345,6 +691,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -361,7 +710,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, Env: env, Body: body}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
@@ -371,7 +720,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(function, args, env, ast.ErrorPosition(node))
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.ArrayLiteral:
@@ -392,6 +741,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	}
 
 	return nil
@@ -31,6 +31,21 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, wanted=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -75,6 +90,19 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"2 ** 62", 4611686018427387904},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"-16 >> 2", -4},
+		{"1 << 63 >> 63", 1},
+		{"-1 >> 100", -1},
+		{"1 + 2 * 3 % 4 << 1 | 1", 5},
 	}
 
 	for _, tt := range tests {
@@ -83,6 +111,83 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalBigIntegerExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{} // Decimal string for a big integer
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"2 ** 64", "18446744073709551616"},
+		{"18446744073709551616", "18446744073709551616"},
+		{"-18446744073709551616 + 1", "-18446744073709551615"},
+		{"-9223372036854775808", -9223372036854775808},
+		{"18446744073709551616 == 2 ** 64", true},
+		{"2 ** 100 / 2 ** 99", 2},
+		{"(2 ** 64 + 5) % 2 ** 64", 5},
+		{"-(2 ** 63)", -9223372036854775808},
+		{"~(2 ** 64)", "-18446744073709551617"},
+		{"2 ** 64 > 2 ** 63", true},
+		{"2 ** 64 == 1 << 64", true},
+		{"2 ** 64 == 18446744073709551616.0", true},
+		{`let h = {2 ** 64: 1}; h[1 << 64]`, 1},
+		{"let f = fn(n, a, b) { if (n == 0) { a } else { f(n - 1, b, a + b) } }; f(100, 0, 1)", "354224848179261915075"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			result, ok := evaluated.(*object.BigInteger)
+			if !ok {
+				t.Errorf("object not BigInteger. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if result.Inspect() != expected {
+				t.Errorf("object has wrong value. got=%s, wanted=%s", result.Inspect(), expected)
+			}
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1.5", 1.5},
+		{"1e-3", 0.001},
+		{"-2.5", -2.5},
+		{"1 + 0.5", 1.5},
+		{"7 / 2", 3},
+		{"7 / 2.0", 3.5},
+		{"7.5 % 2", 1.5},
+		{"2.0 ** 3", 8.0},
+		{"let x = 1; x += 0.5; x", 1.5},
+		{"1 == 1.0", true},
+		{"1 < 1.5", true},
+		{"2.0 >= 2", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{`let h = {1: 10, 2.5: 20}; h[1.0] + h[2.5]`, 30},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -109,6 +214,7 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == 1`, false},
 	}
 
 	for _, tt := range tests {
@@ -117,6 +223,37 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && false", false},
+		{"false || true", true},
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{"0 || 2", 0},
+		{"if (false) { 1 } && 2", nil},
+		{"if (false) { 1 } || 2", 2},
+		{"false && true || 5", 5},
+		{"let n = 0; let f = fn() { n += 1; true }; false && f(); true || f(); n", 0},
+		{"let n = 0; let f = fn() { n += 1; true }; true && f(); false || f(); n", 2},
+		{"false && undefined", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -187,6 +324,204 @@ func TestReturnStatements(t *testing.T) {
 	}
 }
 
+func TestWhileStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 10) { i = i + 1; }; i", 10},
+		{"let i = 0; while (false) { i = i + 1; }; i", 0},
+		{"let i = 0; while (true) { i = i + 1; if (i == 5) { break; } }; i", 5},
+		{
+			`
+			let i = 0;
+			let sum = 0;
+			while (i < 10) {
+				i = i + 1;
+				if (i / 2 * 2 == i) { continue; }
+				sum = sum + i;
+			};
+			sum
+			`,
+			25,
+		},
+		{
+			`
+			let count = fn(n) {
+				let i = 0;
+				let pairs = 0;
+				while (i < n) {
+					let j = 0;
+					while (true) {
+						if (j == i) { break; }
+						pairs = pairs + 1;
+						j = j + 1;
+					}
+					i = i + 1;
+				}
+				pairs
+			};
+			count(5)
+			`,
+			10,
+		},
+		{"let i = 0; while (true) { let x = if (true) { break; }; i = 1; }; i", 0},
+		// A let in the body rebinds the variable, so it is updated on every pass and read after the loop
+		{"let i = 0; let n = 0; while (n < 5) { let i = i + 1; let n = n + 1; }; i", 5},
+		{"let f = fn() { let n = 0; while (n < 3) { let n = n + 1; }; n }; f()", 3},
+		{"let f = fn() { let total = 0; for (x in [1, 2, 3]) { let total = total + x; }; total }; f()", 6},
+		{"while (false) { }", nil},
+		{"let f = fn() { while (false) { } }; f()", nil},
+		{"let f = fn() { let i = 0; while (true) { if (i == 3) { return i * 10; } i = i + 1; } }; f()", 30},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum = sum + x; }; sum", 6},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { sum = sum + i * x; }; sum", 80},
+		{"let n = 0; for (x in []) { n = n + 1; }; n", 0},
+		{`let s = ""; for (c in "abc") { s = c + s; }; s`, "cba"},
+		{`let s = ""; for (i, c in "abc") { if (i == 1) { continue; } s = s + c; }; s`, "ac"},
+		{`let s = ""; for (k in {"b": 2, "c": 3, "a": 1}) { s = s + k; }; s`, "abc"},
+		{`let s = 0; for (k, v in {3: 30, 1: 10, 2: 20}) { s = s * 100 + k * v; }; s`, 104090},
+		{"let last = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } let last = x; }; last", 2},
+		{"let x = 99; for (x in [1, 2]) { }; x", 2},
+		// The names are one binding for the whole loop, so the closures made in the body all see the last element
+		{"let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }); }; fs[0]()", 2},
+		{"let f = fn() { let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }); }; fs[0]() }; f()", 2},
+		{`let f = fn() { let fs = []; for (k, v in {"a": 1, "b": 2}) { fs = push(fs, fn() { v }); }; fs[0]() }; f()`, 2},
+		{"let f = fn() { let fs = []; for (x in [1, 2]) { let y = x; fs = push(fs, fn() { y }); }; fs[0]() + fs[1]() }; f()", 4},
+		{
+			`
+			let find = fn(rows, target) {
+				for (i, row in rows) {
+					for (x in row) {
+						if (x == 0) { break; }
+						if (x == target) { return i; }
+					}
+				}
+				-1
+			};
+			find([[1, 2], [0, 3], [4, 3]], 3) * 10 + find([[1]], 5)
+			`,
+			19,
+		},
+		{"for (x in [1]) { }", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let r = 0; try { throw 5 } catch (e) { r = e }; r`, 5},
+		{`let r = 0; try { r = 1 } catch (e) { r = 2 }; r`, 1},
+		{`let r = ""; try { 1 / 0 } catch (e) { r = e["message"] }; r`, "division by zero"},
+		{`let r = ""; try { len(1) } catch (e) { r = e["message"] }; r`, "argument to len not supported, got INTEGER"},
+		{`let r = -1; try { 1 + "a" } catch (e) { r = len(e["trace"]) }; r`, 1},
+		{`let f = fn() { 1 + "a" }; let r = 0; try { f() } catch (e) { r = len(e["trace"]) }; r`, 2},
+		{`let f = fn() { first(1) }; let r = 0; try { f() } catch (e) { r = e["trace"][0] }; r`, "f (1:16)"},
+		{`let f = fn() { first(1) }; let r = 0; try { f() } catch (e) { r = e["trace"][1] }; r`, "<main> (1:45)"},
+		{`let r = ""; try { [1, 2][5] } catch (e) { r = e["message"] }; r`, "index out of range: 5, length 2"},
+		{`let r = ""; try { [1, 2][2 ** 64] } catch (e) { r = e["message"] }; r`, "index out of range: 18446744073709551616, length 2"},
+		{`let r = 0; try { 1 / 0 } catch (e) { r = e["line"] }; r`, nil},
+		{`let f = fn() { f() }; let r = ""; try { f() } catch (e) { r = e["message"] }; r`, "stack overflow"},
+		{
+			`let g = fn(n) { if (n == 0) { throw "deep" } else { g(n - 1) + 1 } };
+			let r = ""; try { g(10) } catch (e) { r = e }; r`,
+			"deep",
+		},
+		{`let f = fn() { let r = 0; try { throw 1 } catch (e) { r = e + 1 }; r }; f() + f()`, 4},
+		{`let n = 0; for (i in [1, 2, 3]) { try { if (i == 2) { throw i }; n += i } catch (e) { n += 10 * e } }; n`, 24},
+		{`let r = ""; try { try { throw "a" } finally { r = r + "f" } } catch (e) { r = r + e }; r`, "fa"},
+		{`let r = ""; try { try { throw "a" } catch (e) { throw e + "b" } } catch (e) { r = e }; r`, "ab"},
+		{
+			`let log = "";
+			let f = fn() { try { log = log + "1"; return "2"; } finally { log = log + "3" } };
+			let x = f();
+			log + x`,
+			"132",
+		},
+		{
+			`let log = 0;
+			for (i in [1, 2, 3]) { try { if (i == 2) { continue; } if (i == 3) { break; } } finally { log = log * 10 + i } }
+			log`,
+			123,
+		},
+		{`let f = fn() { try { throw 1 } finally { return 2; } }; f()`, 2},
+		{`try { 1 } catch (e) { 2 }`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestCaughtValueTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.ObjectType
+	}{
+		{`let r = 0; try { 1 / 0 } catch (e) { r = e }; r`, object.EXCEPTION_OBJ},
+		{`let r = 0; try { throw {"message": "boom"} } catch (e) { r = e }; r`, object.HASH_OBJ},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.input).Type(); got != tt.expected {
+			t.Errorf("caught value has wrong type. got=%s, want=%s", got, tt.expected)
+		}
+	}
+}
+
 func TestErrorHandling(t *testing.T) {
 	tests := []struct {
 		input           string
@@ -196,10 +531,26 @@ func TestErrorHandling(t *testing.T) {
 			"5 + true;",
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			"[1, 2, 3][3]",
+			"index out of range: 3, length 3",
+		},
+		{
+			"[1, 2, 3][-1]",
+			"index out of range: -1, length 3",
+		},
 		{
 			"5 + true; 5;",
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			"let 5; 1",
+			"cannot evaluate a statement that failed to parse",
+		},
+		{
+			"1 + ;",
+			"cannot evaluate an expression that failed to parse",
+		},
 		{
 			"-true",
 			"unknown operator: -BOOLEAN",
@@ -231,14 +582,138 @@ func TestErrorHandling(t *testing.T) {
 			"foobar",
 			"identifier not found: foobar",
 		},
+		{
+			"1 % 0",
+			"division by zero",
+		},
+		{
+			"2 ** 64 / 0",
+			"division by zero",
+		},
+		{
+			"let x = 10; x / (x - 10)",
+			"division by zero",
+		},
+		{
+			"fn(a) { a }()",
+			"wrong number of arguments: want=1, got=0",
+		},
+		{
+			"fn() { 1 }(1)",
+			"wrong number of arguments: want=0, got=1",
+		},
+		{
+			"let f = fn() { f() }; f();",
+			"stack overflow",
+		},
+		{
+			"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(2000);",
+			"stack overflow",
+		},
+		{
+			"2 ** 10000000000",
+			"integer overflow: result does not fit in 1048576 bits",
+		},
+		{
+			"2 ** -1",
+			"negative exponent: -1",
+		},
+		{
+			"1 << -1",
+			"negative shift count: -1",
+		},
+		{
+			"~true",
+			"unknown operator: ~BOOLEAN",
+		},
+		{
+			"1.5 & 1",
+			"unknown operator: FLOAT & INTEGER",
+		},
+		{
+			"break;",
+			"break outside of a loop",
+		},
+		{
+			"for (x in 1) { }",
+			"cannot iterate over INTEGER",
+		},
+		{
+			"if (true) { continue; }",
+			"continue outside of a loop",
+		},
+		{
+			"while (true) { let f = fn() { break; }; f(); }",
+			"break outside of a loop",
+		},
+		{
+			"y = 1",
+			"cannot assign to undefined variable y",
+		},
+		{
+			"len = 1",
+			"cannot assign to builtin len",
+		},
+		{
+			"let a = [1]; a[1] = 2",
+			"index out of range: 1, length 1",
+		},
+		{
+			`let s = "abc"; s[0] = "x"`,
+			"index assignment not supported: STRING",
+		},
+		{
+			`let h = {}; h["a"] += 1`,
+			"type mismatch: NULL + INTEGER",
+		},
 		{
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			`"a" + 1`,
+			"type mismatch: STRING + INTEGER",
+		},
+		{
+			`"a" < "b"`,
+			"unknown operator: STRING < STRING",
+		},
+		{
+			`"a" <= 1`,
+			"type mismatch: STRING <= INTEGER",
+		},
+		{
+			"[1] < 2",
+			"type mismatch: ARRAY < INTEGER",
+		},
+		{
+			"true > false",
+			"unknown operator: BOOLEAN > BOOLEAN",
+		},
+		{
+			"1(2)",
+			"not a function: INTEGER",
+		},
 		{
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			`throw 1`,
+			"uncaught exception: 1",
+		},
+		{
+			`try { throw 1 } finally { 2 }`,
+			"uncaught exception: 1",
+		},
+		{
+			`try { 1 / 0 } catch (e) { throw e }`,
+			"division by zero",
+		},
+		{
+			`throw {"message": "boom"}`,
+			"boom",
+		},
 	}
 
 	for _, tt := range tests {
@@ -256,6 +731,18 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestComparisonOperandOrder(t *testing.T) {
+	tests := []string{
+		"let n = 0; let f = fn(x) { n = n * 10 + x; x }; f(1) < f(2); n",
+		"let n = 0; let f = fn(x) { n = n * 10 + x; x }; f(1) <= f(2); n",
+		"let n = 0; let f = fn(x) { n = n * 10 + x; x }; f(1) > f(2); n",
+	}
+
+	for _, input := range tests {
+		testIntegerObject(t, testEval(input), 12)
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -272,6 +759,29 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 0; let b = 0; a = b = 3; a + b", 6},
+		{"let i = 0; let sum = 0; while (i < 5) { i += 1; sum += i; }; sum", 15},
+		{"let total = 0; let add = fn(n) { total += n }; add(2); add(3); total", 5},
+		{"let f = fn() { f = 1 }; f(); f", 1},
+		{"let a = [1, 2, 3]; a[1] += 5", 7},
+		{"let a = [1, 2, 3]; let b = a; b[2] = 9; a[2]", 9},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] *= 7; h["a"] + h["b"]`, 9},
+		{"let m = [[1, 2], [3, 4]]; m[1][0] -= 1; m[1][0]", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 
@@ -326,6 +836,28 @@ func TestClosures(t *testing.T) {
 	testIntegerObject(t, testEval(input), 4)
 }
 
+// A closure shares the variables it captures with the function they belong to
+func TestCapturedVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// Assigned by the function after the closure captured it
+		{"let f = fn() { let i = 0; let g = fn() { i }; i += 1; g() }; f()", 1},
+		{"let f = fn(x) { let g = fn() { x }; x = 5; g() }; f(1)", 5},
+		{"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()", 2},
+		// Assigned by the closure
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let f = fn() { let n = 0; let g = fn() { fn() { n = n + 10 } }; g()(); n }; f()", 10},
+		// Every call has variables of its own
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let a = counter(); let b = counter(); a(); a(); b()", 1},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello world!";`
 
@@ -354,6 +886,25 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+// Strings compare by value, whether or not they are the same object
+func TestStringEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{`let s = "a"; s + "b" == "ab"`, true},
+		{`let s = "a"; s + "b" != "ab"`, false},
+		{`let f = fn(x) { x + "!" }; f("hi") == f("hi")`, true},
+		{`"a" == 1`, false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestBuiltInFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -471,14 +1022,6 @@ func TestArrayIndexExpressions(t *testing.T) {
 			"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]",
 			2,
 		},
-		{
-			"[1, 2, 3][3]",
-			nil,
-		},
-		{
-			"[1, 2, 3][-1]",
-			nil,
-		},
 	}
 
 	for _, tt := range tests {
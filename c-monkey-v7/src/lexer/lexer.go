@@ -1,36 +1,83 @@
 package lexer
 
 import (
+	"Compiler/c-monkey-v7/src/diagnostic"
 	"Compiler/c-monkey-v7/src/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// The input is read as UTF-8, one rune at a time. Positions are still byte offsets, so they can be used to slice the input
 type Lexer struct {
 	input    string
 	position int  //pos of current char
 	readPos  int  //next relative char
-	ch       byte //current char
+	ch       rune //current char
+	width    int  //bytes taken by the current char
+
+	file   string // Only used to fill in token positions
+	line   int    //line of current char
+	column int    //column of current char
+
+	diagnostics []*diagnostic.Diagnostic // Errors not yet handed to the parser, see TakeDiagnostics
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFile("", input)
+}
+
+// Same as New, but every token position also carries the name of the file the input was read from
+func NewWithFile(file string, input string) *Lexer {
+	l := &Lexer{input: input, file: file, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.atEOF() { // Stay on the EOF position
+		return
+	}
+
+	if l.ch == '\n' { // Moving past a newline, so the next char starts a new line
+		l.line++
+		l.column = 1
+	} else {
+		l.column += max(l.width, 1) // Columns count bytes, like offsets
+	}
+
 	if l.readPos >= len(l.input) {
 		l.ch = 0 //ASCII for NUL
+		l.width = 0
 	} else {
-		l.ch = l.input[l.readPos]
+		l.ch, l.width = utf8.DecodeRuneInString(l.input[l.readPos:]) // Invalid UTF-8 is utf8.RuneError, one byte wide
 	}
 	l.position = l.readPos
-	l.readPos += 1
+	l.readPos += max(l.width, 1)
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
+// Errors found since the last call, ie, unterminated strings and bad escape sequences. The token with the error is
+// still returned as well as it could be read, so the parser carries on as usual
+func (l *Lexer) TakeDiagnostics() []*diagnostic.Diagnostic {
+	diagnostics := l.diagnostics
+	l.diagnostics = nil
+	return diagnostics
+}
+
+// The error spans from start to the end of the current char
+func (l *Lexer) addError(code diagnostic.Code, start token.Position, format string, a ...interface{}) {
+	end := l.currentPosition()
+	end.Column += l.width
+	end.Offset += l.width
+
+	l.diagnostics = append(l.diagnostics, diagnostic.Errorf(code, diagnostic.Span{Start: start, End: end}, format, a...))
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
 	for isLetter(l.ch) {
@@ -39,19 +86,52 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// Reads an integer, or a float if the digits are followed by a fraction and/or an exponent. The '.' and the 'e'
+// only belong to the number when digits follow them, so 1.x is still an integer followed by '.' and 1e an integer
+// followed by an identifier
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	position := l.position
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if (next == '+' || next == '-') && l.readPos+1 < len(l.input) {
+			next = rune(l.input[l.readPos+1])
+		}
+		if isDigit(next) {
+			tokenType = token.FLOAT
+			l.readChar() // e
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return tokenType, l.input[position:l.position]
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// Any Unicode letter, so identifiers aren't limited to ASCII
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+// Only ASCII digits, other scripts' digits can't start a number
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
@@ -61,29 +141,187 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) peekChar() byte {
+// Skips whitespace and comments up to the next token, returning the comments
+func (l *Lexer) skipTrivia() []token.Comment {
+	var comments []token.Comment
+
+	for {
+		l.skipWhitespace()
+
+		switch {
+		case l.ch == '/' && l.peekChar() == '/':
+			comments = append(comments, l.readLineComment())
+		case l.ch == '/' && l.peekChar() == '*':
+			comments = append(comments, l.readBlockComment())
+		default:
+			return comments
+		}
+	}
+}
+
+// Reads a // comment up to the end of the line, leaving the lexer on the newline
+func (l *Lexer) readLineComment() token.Comment {
+	pos := l.currentPosition()
+
+	for l.ch != '\n' && !l.atEOF() {
+		l.readChar()
+	}
+
+	return token.Comment{Text: strings.TrimRight(l.input[pos.Offset:l.position], "\r"), Pos: pos}
+}
+
+// Reads a /* comment */, leaving the lexer on the char after it. Block comments nest, so that code that already has
+// comments in it can be commented out
+func (l *Lexer) readBlockComment() token.Comment {
+	pos := l.currentPosition()
+	depth := 0
+
+	for {
+		switch {
+		case l.atEOF():
+			l.addError(diagnostic.UnterminatedComment, pos, "unterminated block comment")
+			return token.Comment{Text: l.input[pos.Offset:], Pos: pos}
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+			if depth == 0 {
+				l.readChar()
+				return token.Comment{Text: l.input[pos.Offset:l.position], Pos: pos}
+			}
+		}
+		l.readChar()
+	}
+}
+
+// The lexer is past the last char of the input, as opposed to on a NUL char that is part of it
+func (l *Lexer) atEOF() bool {
+	return l.readPos > len(l.input)
+}
+
+func (l *Lexer) peekChar() rune {
 	if l.readPos >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPos]
+		ch, _ := utf8.DecodeRuneInString(l.input[l.readPos:])
+		return ch
 	}
 }
 
+// Reads a "double quoted" string, starting on the opening quote and stopping on the closing one. Escape sequences
+// are the ones Go has, except for octal ones: \a \b \f \n \r \t \v \\ \' \" and \0, \xHH for a byte, and \uHHHH and
+// \UHHHHHHHH for a Unicode code point
 func (l *Lexer) readString() string {
+	start := l.currentPosition()
+	var out strings.Builder
+
+	for {
+		l.readChar()
+
+		switch l.ch {
+		case '"':
+			return out.String()
+		case 0:
+			if l.atEOF() { // A NUL char in the input is kept, only the end of it stops the string
+				l.addError(diagnostic.UnterminatedString, start, "unterminated string")
+				return out.String()
+			}
+			out.WriteRune(l.ch)
+		case '\\':
+			l.readEscape(&out)
+		default:
+			out.WriteString(l.input[l.position:l.readPos]) // Copied as is, so invalid UTF-8 survives
+		}
+	}
+}
+
+var simpleEscapes = map[rune]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '\'': '\'', '"': '"', '0': 0,
+}
+
+// Reads the escape sequence starting at the backslash the lexer is on, leaving it on the last char of the sequence
+func (l *Lexer) readEscape(out *strings.Builder) {
+	start := l.currentPosition()
+	l.readChar()
+
+	if b, ok := simpleEscapes[l.ch]; ok {
+		out.WriteByte(b)
+		return
+	}
+
+	digits := map[rune]int{'x': 2, 'u': 4, 'U': 8}[l.ch]
+	if digits == 0 {
+		if l.atEOF() {
+			return // Reported as an unterminated string
+		}
+		l.addError(diagnostic.InvalidEscape, start, "unknown escape sequence \\%c", l.ch)
+		return
+	}
+
+	kind := l.ch
+	hex := ""
+	for i := 0; i < digits; i++ {
+		next := l.peekChar()
+		if !isHexDigit(next) {
+			l.addError(diagnostic.InvalidEscape, start, "escape sequence \\%c%s needs %d hex digits", kind, hex, digits)
+			return
+		}
+		l.readChar()
+		hex += string(next)
+	}
+
+	value, _ := strconv.ParseUint(hex, 16, 32)
+	switch {
+	case kind == 'x':
+		out.WriteByte(byte(value))
+	case value > unicode.MaxRune || 0xD800 <= value && value < 0xE000: // Surrogate halves aren't code points
+		l.addError(diagnostic.InvalidEscape, start, "escape sequence \\%c%s is not a valid Unicode code point", kind, hex)
+	default:
+		out.WriteRune(rune(value))
+	}
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// Reads a `raw string`, which has no escape sequences and can span lines
+func (l *Lexer) readRawString() string {
+	start := l.currentPosition()
 	position := l.position + 1
+
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		if l.ch == '`' {
+			return l.input[position:l.position]
+		}
+		if l.atEOF() {
+			l.addError(diagnostic.UnterminatedString, start, "unterminated raw string")
+			return l.input[position:]
 		}
 	}
-	return l.input[position:l.position]
+}
+
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{File: l.file, Line: l.line, Column: l.column, Offset: l.position}
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	comments := l.skipTrivia()
+	pos := l.currentPosition() // Tokens are positioned at their first char
+
+	tok := l.readToken()
+	tok.Pos = pos
+	tok.Comments = comments
+	return tok
+}
 
-	l.skipWhitespace()
+// Reads the token starting at the current char, leaving the lexer on the char after it
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
@@ -93,20 +331,41 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.makeCompoundAssignToken()
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.makeCompoundAssignToken()
+		} else if l.peekChar() == '*' {
+			l.readChar()
+			tok = token.Token{Type: token.POWER, Literal: "**"}
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.makeCompoundAssignToken()
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '<':
 		if l.peekChar() == '=' {
 			tok = l.makeTwoCharComparisonToken()
+		} else if l.peekChar() == '<' {
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_LEFT, Literal: "<<"}
 		} else {
 			tok = newToken(token.LT, l.ch)
 		}
 	case '>':
 		if l.peekChar() == '=' {
 			tok = l.makeTwoCharComparisonToken()
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_RIGHT, Literal: ">>"}
 		} else {
 			tok = newToken(token.GT, l.ch)
 		}
@@ -116,6 +375,24 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.makeLogicalToken()
+		} else {
+			tok = newToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.makeLogicalToken()
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case '(':
@@ -129,10 +406,17 @@ func (l *Lexer) NextToken() token.Token {
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.makeCompoundAssignToken()
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
+	case '`':
+		tok.Type = token.STRING
+		tok.Literal = l.readRawString()
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -140,6 +424,10 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case 0:
+		if !l.atEOF() { // A NUL char in the input, not the end of it
+			tok = newToken(token.ILLEGAL, l.ch)
+			break
+		}
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
@@ -148,11 +436,10 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookUpIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber()
 			return tok
 		} else {
-			return newToken(token.ILLEGAL, l.ch)
+			tok = newToken(token.ILLEGAL, l.ch) // Skipped like any other token, so the lexer still moves on
 		}
 	}
 
@@ -160,6 +447,40 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// Operators followed by '=', ie, +=, -=, *= and /=
+func (l *Lexer) makeCompoundAssignToken() token.Token {
+	ch := l.ch
+	l.readChar()
+
+	switch ch {
+	case '+':
+		return token.Token{Type: token.PLUS_ASSIGN, Literal: string(ch) + string(l.ch)}
+	case '-':
+		return token.Token{Type: token.MINUS_ASSIGN, Literal: string(ch) + string(l.ch)}
+	case '*':
+		return token.Token{Type: token.ASTERISK_ASSIGN, Literal: string(ch) + string(l.ch)}
+	case '/':
+		return token.Token{Type: token.SLASH_ASSIGN, Literal: string(ch) + string(l.ch)}
+	default:
+		return token.Token{Type: token.ILLEGAL, Literal: string(ch)}
+	}
+}
+
+// && and ||
+func (l *Lexer) makeLogicalToken() token.Token {
+	ch := l.ch
+	l.readChar()
+
+	switch ch {
+	case '&':
+		return token.Token{Type: token.AND, Literal: string(ch) + string(l.ch)}
+	case '|':
+		return token.Token{Type: token.OR, Literal: string(ch) + string(l.ch)}
+	default:
+		return token.Token{Type: token.ILLEGAL, Literal: string(ch)}
+	}
+}
+
 func (l *Lexer) makeTwoCharComparisonToken() token.Token {
 	ch := l.ch
 	l.readChar()
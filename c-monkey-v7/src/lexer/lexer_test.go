@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"Compiler/c-monkey-v7/src/token"
+	"reflect"
 	"testing"
 )
 
@@ -28,6 +29,13 @@ func TestNextToken(t *testing.T) {
 	"foo bar"
 	[1, 3];
 	{"foo": "bar"}
+	while (x) { break; continue; }
+	x = 1; x += 1; x -= 1; x *= 2; x /= 2;
+	for (k, v in h) {}
+	a && b || c;
+	7 % 2 ** 3 & 1 | 2 ^ ~3 << 1 >> 2;
+	1.5 0.25e2 1e-3 2E+10 4e 5e+
+	try catch finally throw
 	`
 
 	tests := []struct {
@@ -135,6 +143,89 @@ func TestNextToken(t *testing.T) {
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 
+		{token.WHILE, "while"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.BREAK, "break"},
+		{token.SEMICOLON, ";"},
+		{token.CONTINUE, "continue"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+
+		{token.FOR, "for"},
+		{token.LPAREN, "("},
+		{token.IDENT, "k"},
+		{token.COMMA, ","},
+		{token.IDENT, "v"},
+		{token.IN, "in"},
+		{token.IDENT, "h"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.SEMICOLON, ";"},
+
+		{token.INT, "7"},
+		{token.PERCENT, "%"},
+		{token.INT, "2"},
+		{token.POWER, "**"},
+		{token.INT, "3"},
+		{token.AMPERSAND, "&"},
+		{token.INT, "1"},
+		{token.PIPE, "|"},
+		{token.INT, "2"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.INT, "3"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.INT, "1"},
+		{token.SHIFT_RIGHT, ">>"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "0.25e2"},
+		{token.FLOAT, "1e-3"},
+		{token.FLOAT, "2E+10"},
+		{token.INT, "4"},
+		{token.IDENT, "e"},
+		{token.INT, "5"},
+		{token.IDENT, "e"},
+		{token.PLUS, "+"},
+
+		{token.TRY, "try"},
+		{token.CATCH, "catch"},
+		{token.FINALLY, "finally"},
+		{token.THROW, "throw"},
+
 		{token.EOF, ""},
 	}
 
@@ -152,3 +243,234 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let five = 5;\n  five >= \"ab\";\n\n[1]"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+	}{
+		{token.LET, token.Position{File: "test.mk", Line: 1, Column: 1, Offset: 0}},
+		{token.IDENT, token.Position{File: "test.mk", Line: 1, Column: 5, Offset: 4}},
+		{token.ASSIGN, token.Position{File: "test.mk", Line: 1, Column: 10, Offset: 9}},
+		{token.INT, token.Position{File: "test.mk", Line: 1, Column: 12, Offset: 11}},
+		{token.SEMICOLON, token.Position{File: "test.mk", Line: 1, Column: 13, Offset: 12}},
+		{token.IDENT, token.Position{File: "test.mk", Line: 2, Column: 3, Offset: 16}},
+		{token.GT_EQ, token.Position{File: "test.mk", Line: 2, Column: 8, Offset: 21}},
+		{token.STRING, token.Position{File: "test.mk", Line: 2, Column: 11, Offset: 24}},
+		{token.SEMICOLON, token.Position{File: "test.mk", Line: 2, Column: 15, Offset: 28}},
+		{token.LBRACKET, token.Position{File: "test.mk", Line: 4, Column: 1, Offset: 31}},
+		{token.INT, token.Position{File: "test.mk", Line: 4, Column: 2, Offset: 32}},
+		{token.RBRACKET, token.Position{File: "test.mk", Line: 4, Column: 3, Offset: 33}},
+		{token.EOF, token.Position{File: "test.mk", Line: 4, Column: 4, Offset: 34}},
+	}
+
+	l := NewWithFile("test.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("testing value [%d] - tokentype wrong. expected=%q, got=%q", i+1, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("testing value [%d] - position wrong. expected=%+v, got=%+v", i+1, tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"foo bar"`, "foo bar"},
+		{`""`, ""},
+		{`"a\nb\tc\rd"`, "a\nb\tc\rd"},
+		{`"\a\b\f\v\0"`, "\a\b\f\v\x00"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"it\'s \\ fine"`, `it's \ fine`},
+		{`"\x41\x7a"`, "Az"},
+		{`"\xff"`, "\xff"},
+		{`"café"`, "café"},
+		{`"\U0001F600"`, "\U0001F600"},
+		{`"café ☕"`, "café ☕"},
+		{"`raw \\n \"string\"`", `raw \n "string"`},
+		{"`two\nlines`", "two\nlines"},
+		{"``", ""},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != token.STRING {
+			t.Fatalf("test[%d] - tokentype wrong. expected=%q, got=%q", i, token.STRING, tok.Type)
+		}
+		if tok.Literal != tt.expected {
+			t.Errorf("test[%d] - literal wrong. expected=%q, got=%q", i, tt.expected, tok.Literal)
+		}
+		if diagnostics := l.TakeDiagnostics(); len(diagnostics) != 0 {
+			t.Errorf("test[%d] - unexpected diagnostics: %v", i, diagnostics)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("test[%d] - string did not end where expected, next token is %q", i, next.Literal)
+		}
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		literal  string
+		expected string
+	}{
+		{`let s = "abc`, "abc", "1:9: unterminated string"},
+		{"let s = `abc\n", "abc\n", "1:9: unterminated raw string"},
+		{`"abc\`, "abc", "1:1: unterminated string"},
+		{`"a\qb"`, "ab", `1:3: unknown escape sequence \q`},
+		{`"\x4"`, "", `1:2: escape sequence \x4 needs 2 hex digits`},
+		{`"\u12g4"`, "g4", `1:2: escape sequence \u12 needs 4 hex digits`},
+		{`"\UFFFFFFFF"`, "", `1:2: escape sequence \UFFFFFFFF is not a valid Unicode code point`},
+		{`"\uD800"`, "", `1:2: escape sequence \uD800 is not a valid Unicode code point`},
+		{"\"é\\q\"", "é", `1:4: unknown escape sequence \q`},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+
+		var tok token.Token
+		for tok = l.NextToken(); tok.Type != token.STRING && tok.Type != token.EOF; tok = l.NextToken() {
+		}
+
+		if tok.Literal != tt.literal {
+			t.Errorf("test[%d] - literal wrong. expected=%q, got=%q", i, tt.literal, tok.Literal)
+		}
+
+		diagnostics := l.TakeDiagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("test[%d] - wrong number of diagnostics. want=1, got=%d: %v", i, len(diagnostics), diagnostics)
+		}
+		if diagnostics[0].Error() != tt.expected {
+			t.Errorf("test[%d] - wrong diagnostic. want=%q, got=%q", i, tt.expected, diagnostics[0].Error())
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("test[%d] - expected EOF after the string, got %q", i, next.Literal)
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := "let café = \"thé\";\nπ + naïve_x"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLit  string
+		expectedPos  token.Position
+	}{
+		{token.LET, "let", token.Position{Line: 1, Column: 1, Offset: 0}},
+		{token.IDENT, "café", token.Position{Line: 1, Column: 5, Offset: 4}},
+		{token.ASSIGN, "=", token.Position{Line: 1, Column: 11, Offset: 10}},
+		{token.STRING, "thé", token.Position{Line: 1, Column: 13, Offset: 12}},
+		{token.SEMICOLON, ";", token.Position{Line: 1, Column: 19, Offset: 18}},
+		{token.IDENT, "π", token.Position{Line: 2, Column: 1, Offset: 20}},
+		{token.PLUS, "+", token.Position{Line: 2, Column: 4, Offset: 23}},
+		{token.IDENT, "naïve_x", token.Position{Line: 2, Column: 6, Offset: 25}},
+		{token.EOF, "", token.Position{Line: 2, Column: 14, Offset: 33}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLit {
+			t.Fatalf("testing value [%d] - wrong token. expected=%q %q, got=%q %q", i+1, tt.expectedType, tt.expectedLit, tok.Type, tok.Literal)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("testing value [%d] - position wrong. expected=%+v, got=%+v", i+1, tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// leading\r\nlet x = 1; // trailing\n/* block */ x /* a /* nested */ one */ / 2 /**/;\n// last"
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLit      string
+		expectedComments []token.Comment
+	}{
+		{token.LET, "let", []token.Comment{{Text: "// leading", Pos: token.Position{Line: 1, Column: 1, Offset: 0}}}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "1", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []token.Comment{
+			{Text: "// trailing", Pos: token.Position{Line: 2, Column: 12, Offset: 23}},
+			{Text: "/* block */", Pos: token.Position{Line: 3, Column: 1, Offset: 35}},
+		}},
+		{token.SLASH, "/", []token.Comment{{Text: "/* a /* nested */ one */", Pos: token.Position{Line: 3, Column: 15, Offset: 49}}}},
+		{token.INT, "2", nil},
+		{token.SEMICOLON, ";", []token.Comment{{Text: "/**/", Pos: token.Position{Line: 3, Column: 44, Offset: 78}}}},
+		{token.EOF, "", []token.Comment{{Text: "// last", Pos: token.Position{Line: 4, Column: 1, Offset: 84}}}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLit {
+			t.Fatalf("testing value [%d] - wrong token. expected=%q %q, got=%q %q", i+1, tt.expectedType, tt.expectedLit, tok.Type, tok.Literal)
+		}
+		if !reflect.DeepEqual(tok.Comments, tt.expectedComments) {
+			t.Fatalf("testing value [%d] - comments wrong. expected=%+v, got=%+v", i+1, tt.expectedComments, tok.Comments)
+		}
+	}
+
+	if diagnostics := l.TakeDiagnostics(); len(diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diagnostics)
+	}
+}
+
+func TestUnterminatedComment(t *testing.T) {
+	l := New("1 /* a /* b */ c")
+
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Type != token.EOF {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.EOF, tok.Type)
+	}
+	if len(tok.Comments) != 1 || tok.Comments[0].Text != "/* a /* b */ c" {
+		t.Errorf("comments wrong. got=%+v", tok.Comments)
+	}
+
+	diagnostics := l.TakeDiagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. want=1, got=%d: %v", len(diagnostics), diagnostics)
+	}
+	if diagnostics[0].Error() != "1:3: unterminated block comment" {
+		t.Errorf("wrong diagnostic. got=%q", diagnostics[0].Error())
+	}
+}
+
+func TestIllegalCharsAreSkipped(t *testing.T) {
+	l := New("# @x")
+
+	expected := []token.Token{
+		{Type: token.ILLEGAL, Literal: "#"},
+		{Type: token.ILLEGAL, Literal: "@"},
+		{Type: token.IDENT, Literal: "x"},
+		{Type: token.EOF, Literal: ""},
+	}
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.Type || tok.Literal != tt.Literal {
+			t.Fatalf("testing value [%d] - wrong token. expected=%q %q, got=%q %q", i+1, tt.Type, tt.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
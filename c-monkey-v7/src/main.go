@@ -1,19 +1,323 @@
 package main
 
 import (
+	"Compiler/c-monkey-v7/src/ast"
+	"Compiler/c-monkey-v7/src/compiler"
+	"Compiler/c-monkey-v7/src/diagnostic"
+	"Compiler/c-monkey-v7/src/disassembler"
+	"Compiler/c-monkey-v7/src/evaluator"
+	"Compiler/c-monkey-v7/src/lexer"
+	"Compiler/c-monkey-v7/src/object"
+	"Compiler/c-monkey-v7/src/parser"
 	"Compiler/c-monkey-v7/src/repl"
+	"Compiler/c-monkey-v7/src/vm"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes, so that scripts can tell what went wrong
+const (
+	exitOK           = iota
+	exitFailure      // Reading or writing a file failed
+	exitUsage        // Bad subcommand, flags or arguments
+	exitParseError   // The program has syntax errors
+	exitCompileError // The compiler rejected the program
+	exitRuntimeError // The program failed while running
+)
+
+const usage = `Usage: monkey <command> [arguments]
+
+Commands:
+	run [--engine=vm|eval] <file.mk|file.mbc>   run a program
+	build <file.mk> [-o file.mbc]               compile a program to bytecode
+	disasm <file.mbc>                           print the bytecode of a compiled program
+	repl [--engine=vm|eval]                     start the interactive prompt (the default)
+`
+
+const (
+	vmEngine   = "vm"
+	evalEngine = "eval"
 )
 
 func main() {
-	user, err := user.Current()
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return runRepl(nil, stdin, stdout, stderr)
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "run":
+		return runFile(args, stdout, stderr)
+	case "build":
+		return buildFile(args, stderr)
+	case "disasm":
+		return disasmFile(args, stdout, stderr)
+	case "repl":
+		return runRepl(args, stdin, stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", command, usage)
+		return exitUsage
+	}
+}
+
+func runFile(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("run", stderr)
+	engine := flags.String("engine", vmEngine, "execution engine, vm or eval")
+
+	files, ok := parseArgs(flags, args, 1, stderr)
+	if !ok || !validEngine(*engine, stderr) {
+		return exitUsage
+	}
+	path := files[0]
+
+	var bytecode *compiler.Bytecode
+	var source string
+
+	if filepath.Ext(path) == ".mbc" {
+		if *engine == evalEngine {
+			fmt.Fprintf(stderr, "%s: the eval engine can only run source files\n", path)
+			return exitUsage
+		}
+
+		var status int
+		bytecode, status = loadBytecode(path, stderr)
+		if status != exitOK {
+			return status
+		}
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			return exitFailure
+		}
+		source = string(data)
+
+		program, status := parseSource(path, source, stderr)
+		if status != exitOK {
+			return status
+		}
+
+		if *engine == evalEngine {
+			return evaluate(program, stderr)
+		}
+
+		bytecode, status = compileProgram(program, source, stderr)
+		if status != exitOK {
+			return status
+		}
+	}
+
+	machine := vm.New(bytecode)
+	err := machine.Run()
+	if err != nil {
+		var rtErr *vm.RuntimeError
+		if errors.As(err, &rtErr) {
+			io.WriteString(stderr, rtErr.StackTrace())
+		} else {
+			fmt.Fprintf(stderr, "runtime error: %s\n", err)
+		}
+		return exitRuntimeError
+	}
+
+	return exitOK
+}
+
+func buildFile(args []string, stderr io.Writer) int {
+	flags := newFlagSet("build", stderr)
+	output := flags.String("o", "", "output file, defaults to the source file with a .mbc extension")
+
+	files, ok := parseArgs(flags, args, 1, stderr)
+	if !ok {
+		return exitUsage
+	}
+	path := files[0]
+
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".mbc"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitFailure
+	}
+	source := string(data)
+
+	program, status := parseSource(path, source, stderr)
+	if status != exitOK {
+		return status
+	}
+
+	bytecode, status := compileProgram(program, source, stderr)
+	if status != exitOK {
+		return status
+	}
+
+	out, err := compiler.Marshal(bytecode)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", path, err)
+		return exitCompileError
+	}
+
+	err = os.WriteFile(*output, out, 0644)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+func disasmFile(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("disasm", stderr)
+
+	files, ok := parseArgs(flags, args, 1, stderr)
+	if !ok {
+		return exitUsage
+	}
+
+	bytecode, status := loadBytecode(files[0], stderr)
+	if status != exitOK {
+		return status
+	}
+
+	io.WriteString(stdout, disassembler.Disassemble(bytecode))
+	return exitOK
+}
+
+func runRepl(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("repl", stderr)
+	engine := flags.String("engine", vmEngine, "execution engine, vm or eval")
+
+	_, ok := parseArgs(flags, args, 0, stderr)
+	if !ok || !validEngine(*engine, stderr) {
+		return exitUsage
+	}
+
+	username := "there"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	fmt.Fprintf(stdout, "Hello %s! This is the Monkey programming language!\n", username)
+	fmt.Fprintf(stdout, "Feel free to start typing commands\n")
+
+	if *engine == evalEngine {
+		repl.StartEvaluator(stdin, stdout)
+	} else {
+		repl.Start(stdin, stdout)
+	}
+	return exitOK
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	return flags
+}
+
+// parseArgs allows flags before and after the positional arguments, eg, `build file.mk -o file.mbc`,
+// which the flag package alone does not since it stops at the first non-flag argument
+func parseArgs(flags *flag.FlagSet, args []string, numPositional int, stderr io.Writer) ([]string, bool) {
+	positional := []string{}
+
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, false
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if len(positional) != numPositional {
+		fmt.Fprintf(stderr, "%s: expected %d argument(s), got %d\n\n%s", flags.Name(), numPositional, len(positional), usage)
+		return nil, false
+	}
+
+	return positional, true
+}
+
+func validEngine(engine string, stderr io.Writer) bool {
+	if engine != vmEngine && engine != evalEngine {
+		fmt.Fprintf(stderr, "unknown engine %q, want %s or %s\n", engine, vmEngine, evalEngine)
+		return false
+	}
+	return true
+}
+
+func parseSource(path, source string, stderr io.Writer) (*ast.Program, int) {
+	l := lexer.NewWithFile(path, source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		for _, d := range p.Diagnostics() {
+			diagnostic.Render(stderr, source, d)
+		}
+		return nil, exitParseError
+	}
+
+	return program, exitOK
+}
+
+func compileProgram(program *ast.Program, source string, stderr io.Writer) (*compiler.Bytecode, int) {
+	comp := compiler.New()
+	err := comp.Compile(program)
 	if err != nil {
-		panic(err)
+		var d *diagnostic.Diagnostic
+		if errors.As(err, &d) {
+			diagnostic.Render(stderr, source, d)
+		} else {
+			fmt.Fprintf(stderr, "compilation failed: %s\n", err)
+		}
+		return nil, exitCompileError
+	}
+
+	return comp.Bytecode(), exitOK
+}
+
+func loadBytecode(path string, stderr io.Writer) (*compiler.Bytecode, int) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return nil, exitFailure
+	}
+
+	bytecode, err := compiler.Unmarshal(data)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", path, err)
+		return nil, exitFailure
+	}
+
+	return bytecode, exitOK
+}
+
+func evaluate(program *ast.Program, stderr io.Writer) int {
+	evaluated := evaluator.Eval(program, object.NewEnvironment())
+	if errObj, ok := evaluated.(*object.Error); ok {
+		fmt.Fprintf(stderr, "runtime error: %s\n", errObj.Message)
+		return exitRuntimeError
 	}
 
-	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
-	fmt.Printf("Feel free to start typing commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	return exitOK
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, source string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(source), 0644)
+		if err != nil {
+			t.Fatalf("could not write %s: %s", path, err)
+		}
+		return path
+	}
+
+	ok := write("ok.mk", "let a = [1, 2]; len(a);")
+	parseError := write("parse.mk", "let x = 1 +;")
+	compileError := write("compile.mk", "y;")
+	runtimeError := write("runtime.mk", `1 + "a";`)
+
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"run", ok}, exitOK},
+		{[]string{"run", "--engine=eval", ok}, exitOK},
+		{[]string{"run", parseError}, exitParseError},
+		{[]string{"run", "--engine=eval", parseError}, exitParseError},
+		{[]string{"run", compileError}, exitCompileError},
+		{[]string{"run", "--engine=eval", compileError}, exitRuntimeError}, // The evaluator only finds out while running
+		{[]string{"run", runtimeError}, exitRuntimeError},
+		{[]string{"run", "--engine=eval", runtimeError}, exitRuntimeError},
+		{[]string{"run", filepath.Join(dir, "missing.mk")}, exitFailure},
+		{[]string{"run", "--engine=js", ok}, exitUsage},
+		{[]string{"run"}, exitUsage},
+		{[]string{"run", ok, ok}, exitUsage},
+		{[]string{"build", compileError, "-o", filepath.Join(dir, "compile.mbc")}, exitCompileError},
+		{[]string{"disasm", ok}, exitFailure}, // Source files are not bytecode
+		{[]string{"fly"}, exitUsage},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, strings.NewReader(""), &stdout, &stderr)
+		if code != tt.expected {
+			t.Errorf("monkey %s: wrong exit code. want=%d, got=%d (stderr=%q)",
+				strings.Join(tt.args, " "), tt.expected, code, stderr.String())
+		}
+	}
+}
+
+func TestBuildRunAndDisasm(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "prog.mk")
+	err := os.WriteFile(source, []byte("let double = fn(x) { x * 2 }; double(21);"), 0644)
+	if err != nil {
+		t.Fatalf("could not write %s: %s", source, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	// Without -o the output lands next to the source
+	if code := run([]string{"build", source}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr.String())
+	}
+	output := filepath.Join(dir, "prog.mbc")
+
+	if code := run([]string{"run", output}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("run failed with %d: %s", code, stderr.String())
+	}
+
+	if code := run([]string{"disasm", output}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("disasm failed with %d: %s", code, stderr.String())
+	}
+
+	for _, expected := range []string{"== main ==\n0000 OpClosure", "fn double (1 parameter, 1 local, 0 free variables) ==\n0000 OpGetLocal 0 "} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("disassembly does not contain %q. got=%q", expected, stdout.String())
+		}
+	}
+}
+
+func TestReplEngines(t *testing.T) {
+	for _, engine := range []string{"vm", "eval"} {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"repl", "--engine=" + engine}, strings.NewReader("let a = 5; a * 2\n"), &stdout, &stderr)
+		if code != exitOK {
+			t.Fatalf("repl with %s engine failed with %d: %s", engine, code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), "10\n") {
+			t.Errorf("repl with %s engine printed wrong output. got=%q", engine, stdout.String())
+		}
+	}
+}
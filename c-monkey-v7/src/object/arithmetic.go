@@ -0,0 +1,276 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// Integer arithmetic shared by the VM and the evaluator so that both give the same results and the same errors.
+// Integers are *Integer while they fit in an int64 and *BigInteger once they don't. Operations on two *Integer
+// values stay on int64 unless the result overflows, in which case they are redone with math/big
+
+// Largest integer result, in bits, so that something like 1 << 10000000000 fails instead of exhausting memory
+const MaxIntegerBits = 1 << 20
+
+// Applies one of + - * / % ** & | ^ << >> to two integers, each an *Integer or *BigInteger
+func IntegerOperation(operator string, left, right Object) (Object, error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		result, ok, err := smallIntegerOperation(operator, l.Value, r.Value)
+		if err != nil || ok {
+			return result, err
+		}
+	}
+
+	return bigIntegerOperation(operator, toBig(left), toBig(right))
+}
+
+// The int64 fast path. ok is false when the result does not fit, so it has to be computed on big integers
+func smallIntegerOperation(operator string, left, right int64) (Object, bool, error) {
+	var result int64
+
+	switch operator {
+	case "+":
+		result = left + right
+		if (left > 0 && right > 0 && result < 0) || (left < 0 && right < 0 && result >= 0) {
+			return nil, false, nil
+		}
+	case "-":
+		result = left - right
+		if (left >= 0 && right < 0 && result < 0) || (left < 0 && right > 0 && result >= 0) {
+			return nil, false, nil
+		}
+	case "*":
+		product, ok := multiply(left, right)
+		if !ok {
+			return nil, false, nil
+		}
+		result = product
+	case "/":
+		if right == 0 {
+			return nil, false, fmt.Errorf("division by zero")
+		}
+		if left == math.MinInt64 && right == -1 {
+			return nil, false, nil
+		}
+		result = left / right // Truncated towards zero
+	case "%":
+		if right == 0 {
+			return nil, false, fmt.Errorf("division by zero")
+		}
+		result = left % right // Truncated like /, so the result has the sign of left
+	case "**":
+		if right < 0 {
+			return nil, false, fmt.Errorf("negative exponent: %d", right)
+		}
+		result = 1
+		base := left
+		for exponent := right; exponent > 0; exponent >>= 1 { // Square and multiply
+			var ok bool
+			if exponent&1 == 1 {
+				if result, ok = multiply(result, base); !ok {
+					return nil, false, nil
+				}
+			}
+			if exponent > 1 {
+				if base, ok = multiply(base, base); !ok {
+					return nil, false, nil
+				}
+			}
+		}
+	case "&":
+		result = left & right
+	case "|":
+		result = left | right
+	case "^":
+		result = left ^ right
+	case "<<":
+		if right < 0 {
+			return nil, false, fmt.Errorf("negative shift count: %d", right)
+		}
+		if left == 0 {
+			result = 0
+			break
+		}
+		if right >= 63 || left<<uint64(right)>>uint64(right) != left {
+			return nil, false, nil
+		}
+		result = left << uint64(right)
+	case ">>":
+		if right < 0 {
+			return nil, false, fmt.Errorf("negative shift count: %d", right)
+		}
+		result = left >> uint64(min(right, 63)) // Every bit shifted out leaves the sign, ie, 0 or -1
+	default:
+		return nil, false, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+
+	return &Integer{Value: result}, true, nil
+}
+
+func bigIntegerOperation(operator string, left, right *big.Int) (Object, error) {
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		result.Add(left, right)
+	case "-":
+		result.Sub(left, right)
+	case "*":
+		result.Mul(left, right)
+	case "/":
+		if right.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result.Quo(left, right) // Quo and Rem truncate like int64 does, Div and Mod wouldn't
+	case "%":
+		if right.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result.Rem(left, right)
+	case "**":
+		if right.Sign() < 0 {
+			return nil, fmt.Errorf("negative exponent: %s", right)
+		}
+		if left.CmpAbs(big.NewInt(1)) > 0 { // |left| >= 2, so the result has at least as many bits as the exponent
+			if !right.IsInt64() || right.Int64() > MaxIntegerBits || int64(left.BitLen()-1)*right.Int64() > MaxIntegerBits {
+				return nil, tooLarge()
+			}
+		}
+		result.Exp(left, right, nil)
+	case "&":
+		result.And(left, right)
+	case "|":
+		result.Or(left, right)
+	case "^":
+		result.Xor(left, right)
+	case "<<":
+		if right.Sign() < 0 {
+			return nil, fmt.Errorf("negative shift count: %s", right)
+		}
+		if left.Sign() != 0 {
+			if !right.IsInt64() || int64(left.BitLen())+right.Int64() > MaxIntegerBits {
+				return nil, tooLarge()
+			}
+			result.Lsh(left, uint(right.Int64()))
+		}
+	case ">>":
+		if right.Sign() < 0 {
+			return nil, fmt.Errorf("negative shift count: %s", right)
+		}
+		shift := uint(MaxIntegerBits + 1) // Anything larger shifts out every bit as well
+		if right.IsInt64() && right.Int64() < int64(shift) {
+			shift = uint(right.Int64())
+		}
+		result.Rsh(left, shift) // Rounds towards negative infinity, like >> on int64
+	default:
+		return nil, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+
+	if result.BitLen() > MaxIntegerBits {
+		return nil, tooLarge()
+	}
+
+	return NewInteger(result), nil
+}
+
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	return NewInteger(new(big.Int).Neg(toBig(obj)))
+}
+
+// ~x, which is -x - 1 and so never overflows
+func BitNotInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok {
+		return &Integer{Value: ^i.Value}
+	}
+	return NewInteger(new(big.Int).Not(toBig(obj)))
+}
+
+// -1, 0 or 1 as left is less than, equal to or greater than right
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return toBig(left).Cmp(toBig(right))
+}
+
+// Either of the two integer representations
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInteger:
+		return true
+	default:
+		return false
+	}
+}
+
+// The integer object for value, an *Integer if it fits in an int64. Big integers are only ever created through
+// here, so a *BigInteger never holds a value an *Integer could
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInteger{Value: value}
+}
+
+func toBig(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInteger:
+		return obj.Value
+	default:
+		panic(fmt.Sprintf("not an integer: %s", obj.Type()))
+	}
+}
+
+// ok is false if the product doesn't fit in an int64
+func multiply(left, right int64) (int64, bool) {
+	hi, lo := bits.Mul64(uint64(abs(left)), uint64(abs(right)))
+	if hi != 0 || lo > math.MaxInt64 { // Also rules out MinInt64 as a result, which big integers demote again anyway
+		return 0, false
+	}
+	return left * right, true
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n // MinInt64 stays negative, but as a uint64 it is still the right magnitude
+	}
+	return n
+}
+
+func tooLarge() error {
+	return fmt.Errorf("integer overflow: result does not fit in %d bits", MaxIntegerBits)
+}
+
+// The value of an integer or float as a float, used when one operand of an arithmetic operation or comparison is
+// a float. Integers beyond 2^53 lose precision, like they would in a float literal
+func ToFloat(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *BigInteger:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f, true
+	case *Float:
+		return obj.Value, true
+	default:
+		return 0, false
+	}
+}
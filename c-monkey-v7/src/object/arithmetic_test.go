@@ -0,0 +1,133 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestIntegerOperation(t *testing.T) {
+	maxInt := &Integer{Value: math.MaxInt64}
+	minInt := &Integer{Value: math.MinInt64}
+	twoTo64, _ := new(big.Int).SetString("18446744073709551616", 10)
+	big64 := &BigInteger{Value: twoTo64}
+
+	tests := []struct {
+		operator    string
+		left, right Object
+		expected    string // Inspect of the result
+		big         bool   // Whether the result is a *BigInteger
+	}{
+		{"+", &Integer{Value: 1}, &Integer{Value: 2}, "3", false},
+		{"+", maxInt, &Integer{Value: 1}, "9223372036854775808", true},
+		{"-", minInt, &Integer{Value: 1}, "-9223372036854775809", true},
+		{"-", &Integer{Value: 0}, minInt, "9223372036854775808", true},
+		{"*", maxInt, &Integer{Value: 2}, "18446744073709551614", true},
+		{"*", &Integer{Value: -1}, minInt, "9223372036854775808", true},
+		{"*", &Integer{Value: 1 << 32}, &Integer{Value: -(1 << 31)}, "-9223372036854775808", false},
+		{"/", minInt, &Integer{Value: -1}, "9223372036854775808", true},
+		{"/", &Integer{Value: -7}, &Integer{Value: 2}, "-3", false},
+		{"%", &Integer{Value: -7}, &Integer{Value: 2}, "-1", false},
+		{"%", minInt, &Integer{Value: -1}, "0", false},
+		{"**", &Integer{Value: 2}, &Integer{Value: 62}, "4611686018427387904", false},
+		{"**", &Integer{Value: 2}, &Integer{Value: 64}, "18446744073709551616", true},
+		{"**", &Integer{Value: -1}, maxInt, "-1", false},
+		{"**", &Integer{Value: 0}, maxInt, "0", false},
+		{"<<", &Integer{Value: 1}, &Integer{Value: 62}, "4611686018427387904", false},
+		{"<<", &Integer{Value: -1}, &Integer{Value: 63}, "-9223372036854775808", false},
+		{"<<", &Integer{Value: 1}, &Integer{Value: 64}, "18446744073709551616", true},
+		{"<<", &Integer{Value: 0}, maxInt, "0", false},
+		{">>", &Integer{Value: -8}, &Integer{Value: 1000}, "-1", false},
+		{">>", maxInt, &Integer{Value: 64}, "0", false},
+
+		// Big integers demote again once the result fits
+		{"-", big64, &Integer{Value: 1}, "18446744073709551615", true},
+		{"/", big64, &Integer{Value: 4}, "4611686018427387904", false},
+		{"-", big64, big64, "0", false},
+		{">>", big64, &Integer{Value: 64}, "1", false},
+		{">>", big64, big64, "0", false},
+		{"&", big64, &Integer{Value: -1}, "18446744073709551616", true},
+		{"%", big64, &Integer{Value: 7}, "2", false},
+	}
+
+	for _, tt := range tests {
+		result, err := IntegerOperation(tt.operator, tt.left, tt.right)
+		if err != nil {
+			t.Errorf("%s %s %s returned error: %s", tt.left.Inspect(), tt.operator, tt.right.Inspect(), err)
+			continue
+		}
+
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s %s %s = %s, want %s", tt.left.Inspect(), tt.operator, tt.right.Inspect(), result.Inspect(), tt.expected)
+		}
+
+		if _, isBig := result.(*BigInteger); isBig != tt.big {
+			t.Errorf("%s %s %s is %T, want big=%t", tt.left.Inspect(), tt.operator, tt.right.Inspect(), result, tt.big)
+		}
+	}
+}
+
+func TestIntegerOperationErrors(t *testing.T) {
+	twoTo64, _ := new(big.Int).SetString("18446744073709551616", 10)
+	big64 := &BigInteger{Value: twoTo64}
+
+	tests := []struct {
+		operator    string
+		left, right Object
+		expected    string
+	}{
+		{"/", &Integer{Value: 1}, &Integer{Value: 0}, "division by zero"},
+		{"%", big64, &Integer{Value: 0}, "division by zero"},
+		{"**", &Integer{Value: 2}, &Integer{Value: -1}, "negative exponent: -1"},
+		{"<<", &Integer{Value: 1}, &Integer{Value: -1}, "negative shift count: -1"},
+		{"<<", &Integer{Value: 1}, &Integer{Value: MaxIntegerBits}, "integer overflow"},
+		{"**", &Integer{Value: 2}, &Integer{Value: math.MaxInt64}, "integer overflow"},
+		{"**", big64, big64, "integer overflow"},
+	}
+
+	for _, tt := range tests {
+		_, err := IntegerOperation(tt.operator, tt.left, tt.right)
+		if err == nil {
+			t.Errorf("%s %s %s returned no error", tt.left.Inspect(), tt.operator, tt.right.Inspect())
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("wrong error for %s %s %s. want=%q, got=%q", tt.left.Inspect(), tt.operator, tt.right.Inspect(), tt.expected, err)
+		}
+	}
+}
+
+func TestNegateInteger(t *testing.T) {
+	result := NegateInteger(&Integer{Value: math.MinInt64})
+	if result.Inspect() != "9223372036854775808" {
+		t.Errorf("wrong negation of MinInt64. got=%s", result.Inspect())
+	}
+
+	result = NegateInteger(result)
+	if i, ok := result.(*Integer); !ok || i.Value != math.MinInt64 {
+		t.Errorf("negating back did not demote to MinInt64. got=%T (%s)", result, result.Inspect())
+	}
+}
+
+func TestCompareIntegers(t *testing.T) {
+	twoTo64, _ := new(big.Int).SetString("18446744073709551616", 10)
+	big64 := &BigInteger{Value: twoTo64}
+	negative := &BigInteger{Value: new(big.Int).Neg(twoTo64)}
+
+	tests := []struct {
+		left, right Object
+		expected    int
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 2}, -1},
+		{big64, &Integer{Value: math.MaxInt64}, 1},
+		{negative, &Integer{Value: math.MinInt64}, -1},
+		{big64, &BigInteger{Value: new(big.Int).Set(twoTo64)}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := CompareIntegers(tt.left, tt.right); got != tt.expected {
+			t.Errorf("CompareIntegers(%s, %s) = %d, want %d", tt.left.Inspect(), tt.right.Inspect(), got, tt.expected)
+		}
+	}
+}
@@ -10,7 +10,7 @@ var Builtins = []struct {
 		"len",
 		&Builtin{Fn: func(args ...Object) Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got+%d, want=1", len(args))
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
 			}
 
 			switch arg := args[0].(type) {
@@ -19,7 +19,7 @@ var Builtins = []struct {
 			case *String:
 				return &Integer{Value: int64(len(arg.Value))}
 			default:
-				return newError("argument to `len` not supported, got=%s", args[0].Type())
+				return newError("argument to len not supported, got %s", args[0].Type())
 			}
 		},
 		},
@@ -45,7 +45,7 @@ var Builtins = []struct {
 			}
 
 			if args[0].Type() != ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
+				return newError("argument to first must be ARRAY, got %s", args[0].Type())
 			}
 
 			arr := args[0].(*Array)
@@ -65,7 +65,7 @@ var Builtins = []struct {
 			}
 
 			if args[0].Type() != ARRAY_OBJ {
-				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+				return newError("argument to last must be ARRAY, got %s", args[0].Type())
 			}
 
 			arr := args[0].(*Array)
@@ -86,7 +86,7 @@ var Builtins = []struct {
 			}
 
 			if args[0].Type() != ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY. got=%s", args[0].Type())
+				return newError("argument to rest must be ARRAY, got %s", args[0].Type())
 			}
 
 			arr := args[0].(*Array)
@@ -109,7 +109,7 @@ var Builtins = []struct {
 			}
 
 			if args[0].Type() != ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+				return newError("argument to push must be ARRAY, got %s", args[0].Type())
 			}
 
 			arr := args[0].(*Array)
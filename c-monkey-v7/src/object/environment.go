@@ -1,7 +1,53 @@
 package object
 
+import (
+	"Compiler/c-monkey-v7/src/token"
+	"fmt"
+)
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.depth = outer.depth
+	return env
+}
+
+// The environment of a function call. Its names are looked up in outer, the environment the function was defined in,
+// but its depth is one more than caller's so that runaway recursion can be stopped before it exhausts the Go stack.
+// function is the name the call has in a trace and pos is where it was made from
+func NewCallEnvironment(outer, caller *Environment, function string, pos token.Position) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.depth = caller.depth + 1
+	env.caller = caller
+	env.function = function
+	env.callPos = pos
 	return env
 }
+
+func (e *Environment) Depth() int {
+	return e.depth
+}
+
+// The calls running in this environment, innermost first, listed like the VM lists the frames of a runtime error,
+// ie, "add (2:5)". pos is where the innermost call is, and each call before it is at the position it was made from
+func (e *Environment) Trace(pos token.Position) []string {
+	trace := []string{}
+
+	for env := e; ; env = env.caller {
+		function := "<main>"
+		if env.caller != nil {
+			function = env.function
+		}
+
+		if pos.IsValid() {
+			trace = append(trace, fmt.Sprintf("%s (%s)", function, pos))
+		} else {
+			trace = append(trace, function)
+		}
+
+		if env.caller == nil {
+			return trace
+		}
+		pos = env.callPos
+	}
+}
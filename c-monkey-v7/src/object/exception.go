@@ -0,0 +1,50 @@
+package object
+
+// A catch receives what was thrown as is. An error raised by the interpreter or a builtin is caught as an Exception
+// instead, which can be indexed like a hash for its "message" and its "trace", the calls that were running innermost
+// first. Being its own type, it can't be mixed up with a thrown hash that happens to have the same keys
+type Exception struct {
+	Message string
+	Trace   []string
+}
+
+func (e *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (e *Exception) Inspect() string  { return "error: " + e.Message }
+
+// The value of e[key], nil if there is no such field
+func (e *Exception) Field(key Object) Object {
+	name, ok := key.(*String)
+	if !ok {
+		return nil
+	}
+
+	switch name.Value {
+	case "message":
+		return &String{Value: e.Message}
+	case "trace":
+		frames := []Object{}
+		for _, f := range e.Trace {
+			frames = append(frames, &String{Value: f})
+		}
+		return &Array{Elements: frames}
+	default:
+		return nil
+	}
+}
+
+// The message to report for a thrown value that was never caught. A caught error that is thrown again, or any hash
+// with a string "message", keeps its own message
+func UncaughtMessage(value Object) string {
+	if exception, ok := value.(*Exception); ok {
+		return exception.Message
+	}
+	if hash, ok := value.(*Hash); ok {
+		if pair, ok := hash.Pairs[(&String{Value: "message"}).HashKey()]; ok {
+			if message, ok := pair.Value.(*String); ok {
+				return message.Value
+			}
+		}
+	}
+
+	return "uncaught exception: " + value.Inspect()
+}
@@ -0,0 +1,73 @@
+package object
+
+import "fmt"
+
+// Iterator walks an array, a string or a hash for a for-in loop. The elements are copied when it is created, so
+// the loop body can't change what is being iterated over
+type Iterator struct {
+	keys   []Object // Only for hashes. Arrays and strings are keyed by index
+	values []Object
+	pos    int
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return fmt.Sprintf("iterator[%d/%d]", it.pos, len(it.values)) }
+
+// Strings are walked by character (rune), and hashes in the order of Hash.SortedPairs
+func NewIterator(obj Object) (*Iterator, error) {
+	switch obj := obj.(type) {
+	case *Array:
+		values := make([]Object, len(obj.Elements))
+		copy(values, obj.Elements)
+		return &Iterator{values: values}, nil
+
+	case *String:
+		values := []Object{}
+		for _, r := range obj.Value {
+			values = append(values, &String{Value: string(r)})
+		}
+		return &Iterator{values: values}, nil
+
+	case *Hash:
+		it := &Iterator{keys: []Object{}, values: []Object{}}
+		for _, pair := range obj.SortedPairs() {
+			it.keys = append(it.keys, pair.Key)
+			it.values = append(it.values, pair.Value)
+		}
+		return it, nil
+
+	default:
+		return nil, fmt.Errorf("cannot iterate over %s", obj.Type())
+	}
+}
+
+// Advances the iterator. The key is the index for arrays and strings and the key itself for hashes
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	if it.pos >= len(it.values) {
+		return nil, nil, false
+	}
+
+	if it.keys != nil {
+		key = it.keys[it.pos]
+	} else {
+		key = &Integer{Value: int64(it.pos)}
+	}
+	value = it.values[it.pos]
+
+	it.pos++
+	return key, value, true
+}
+
+// Advances the iterator and returns what a loop with a single name binds, ie, the element of an array or string,
+// but the key of a hash
+func (it *Iterator) NextElement() (Object, bool) {
+	key, value, ok := it.Next()
+	if !ok {
+		return nil, false
+	}
+
+	if it.keys != nil {
+		return key, true
+	}
+	return value, true
+}
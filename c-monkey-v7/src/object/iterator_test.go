@@ -0,0 +1,103 @@
+package object
+
+import "testing"
+
+func TestIterator(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, key := range []Object{
+		&String{Value: "b"}, &Integer{Value: 10}, &String{Value: "a"}, &Boolean{Value: true},
+		&Integer{Value: -3}, &Boolean{Value: false},
+	} {
+		hash.Pairs[key.(Hashable).HashKey()] = HashPair{Key: key, Value: &String{Value: "v" + key.Inspect()}}
+	}
+
+	tests := []struct {
+		iterable         Object
+		expectedKeys     []string
+		expectedValues   []string
+		expectedElements []string
+	}{
+		{
+			&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "two"}}},
+			[]string{"0", "1"},
+			[]string{"1", "two"},
+			[]string{"1", "two"},
+		},
+		{
+			&String{Value: "héj"},
+			[]string{"0", "1", "2"},
+			[]string{"h", "é", "j"},
+			[]string{"h", "é", "j"},
+		},
+		{
+			// Grouped by type, then ordered by value
+			hash,
+			[]string{"false", "true", "-3", "10", "a", "b"},
+			[]string{"vfalse", "vtrue", "v-3", "v10", "va", "vb"},
+			[]string{"false", "true", "-3", "10", "a", "b"},
+		},
+		{&Array{}, []string{}, []string{}, []string{}},
+	}
+
+	for _, tt := range tests {
+		it, err := NewIterator(tt.iterable)
+		if err != nil {
+			t.Fatalf("NewIterator failed: %s", err)
+		}
+
+		keys, values := []string{}, []string{}
+		for {
+			key, value, ok := it.Next()
+			if !ok {
+				break
+			}
+			keys = append(keys, key.Inspect())
+			values = append(values, value.Inspect())
+		}
+		checkStrings(t, "keys", tt.expectedKeys, keys)
+		checkStrings(t, "values", tt.expectedValues, values)
+
+		it, _ = NewIterator(tt.iterable)
+		elements := []string{}
+		for {
+			element, ok := it.NextElement()
+			if !ok {
+				break
+			}
+			elements = append(elements, element.Inspect())
+		}
+		checkStrings(t, "elements", tt.expectedElements, elements)
+	}
+}
+
+func TestIteratorCopiesElements(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}}}
+	it, _ := NewIterator(array)
+	array.Elements[0] = &Integer{Value: 2}
+
+	element, _ := it.NextElement()
+	if element.Inspect() != "1" {
+		t.Errorf("iterator saw a change made after it was created. got=%s", element.Inspect())
+	}
+}
+
+func TestIteratorNotIterable(t *testing.T) {
+	_, err := NewIterator(&Integer{Value: 1})
+	if err == nil || err.Error() != "cannot iterate over INTEGER" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func checkStrings(t *testing.T, what string, expected, actual []string) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("wrong number of %s. want=%v, got=%v", what, expected, actual)
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Errorf("wrong %s. want=%v, got=%v", what, expected, actual)
+			return
+		}
+	}
+}
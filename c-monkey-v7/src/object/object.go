@@ -3,9 +3,14 @@ package object
 import (
 	"Compiler/c-monkey-v7/src/ast"
 	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/token"
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -14,16 +19,23 @@ type BuiltInFunction func(args ...Object) Object
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	STRING_OBJ            = "STRING"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
 	ERROR_OBJ             = "ERROR"
+	EXCEPTION_OBJ         = "EXCEPTION"
 	FUNCTION_OBJ          = "FUNCTION"
 	BUILTIN_OBJ           = "BUILTIN"
 	ARRAY_OBJ             = "ARRAY"
 	HASH_OBJ              = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
+	CLOSURE_OBJ           = "CLOSURE"
+	ITERATOR_OBJ          = "ITERATOR"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -38,6 +50,29 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+// An integer outside the range of an int64. Reports the same type as Integer, so scripts never see the difference
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (bi *BigInteger) Inspect() string  { return bi.Value.String() }
+func (bi *BigInteger) Type() ObjectType { return INTEGER_OBJ }
+
+type Float struct {
+	Value float64
+}
+
+// The shortest representation that parses back to the same value. Whole numbers keep a ".0" so they don't read as
+// integers, ie, 2.0 rather than 2
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { // Not already a fraction, an exponent, Inf or NaN
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 type Boolean struct {
 	Value bool
 }
@@ -57,8 +92,22 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue are only used by the evaluator, to unwind out of the blocks of a loop body like ReturnValue
+// unwinds out of a function body
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
 type Error struct {
 	Message string
+	Value   Object   // What was thrown, nil for an error raised by the interpreter or a builtin
+	Trace   []string // The calls that were running where an error of the interpreter was raised, nil until it is known
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
@@ -67,6 +116,12 @@ func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	depth int // Number of function calls active when this environment was created
+
+	// Set for the environment of a function call, see Trace
+	caller   *Environment
+	function string         // Name of the function, as in the trace of an error
+	callPos  token.Position // Position of the call in the caller
 }
 
 func NewEnvironment() *Environment {
@@ -87,7 +142,19 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+// The environment the name is bound in, which is either this one or one of its enclosing environments
+func (e *Environment) Resolve(name string) (*Environment, bool) {
+	if _, ok := e.store[name]; ok {
+		return e, true
+	}
+	if e.outer != nil {
+		return e.outer.Resolve(name)
+	}
+	return nil, false
+}
+
 type Function struct {
+	Name       string // Name of the let binding the function was defined with, empty for anonymous functions
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment // This is the environment that the function is in, not the function's environment
@@ -167,6 +234,24 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// Big integers never hold a value that fits an int64, so their keys are kept apart from those of small integers
+const bigIntegerKey ObjectType = "BIG_INTEGER"
+
+func (bi *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(bi.Value.String()))
+	return HashKey{Type: bigIntegerKey, Value: h.Sum64()}
+}
+
+// A whole float is the same key as the equal integer, since the two compare equal, ie, h[1] and h[1.0] are the same entry
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		value, _ := new(big.Float).SetFloat64(f.Value).Int(nil)
+		return NewInteger(value).(Hashable).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -201,6 +286,37 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// The pairs ordered by key, so that iterating over a hash does not depend on Go's random map order. Keys of
+// different types are grouped by type, and within a type compared by value
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return keyLess(pairs[i].Key, pairs[j].Key) })
+	return pairs
+}
+
+func keyLess(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+
+	switch a := a.(type) {
+	case *Integer, *BigInteger: // Same type, but either can be on both sides
+		return CompareIntegers(a, b) < 0
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	default:
+		return a.Inspect() < b.Inspect()
+	}
+}
+
 type Hashable interface {
 	HashKey() HashKey
 }
@@ -209,9 +325,40 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int // Number of local bindings in the function
 	NumParameters int
+	SourceMap     code.SourceMap    // Source positions of Instructions, used to locate runtime errors
+	Name          string            // Name of the let binding the function was defined with, empty for anonymous functions
+	LocalNames    []string          // Names of the local slots, parameters first. Debug info only, like SourceMap
+	FreeNames     []string          // Names of the captured free variables, in the order of OpGetFree indexes
+	Handlers      code.HandlerTable // Where errors raised inside a try are caught
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
 func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
+
+// Every CompiledFunction is wrapped in a Closure at runtime, so that it can carry the free variables it captured when it was created
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object // Cells of the free variables, in the same order as the compiler's FreeSymbols, see Cell
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// A local variable that a closure captured. The cell takes the place of the value in the local's slot, so the frame
+// and every closure that captured the variable share it and see each other's assignments, like functions sharing an
+// Environment in the evaluator. A closure that captures the function it is defined in holds the closure itself instead
+type Cell struct {
+	Value Object // nil until the variable is bound
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	if c.Value == nil {
+		return "null"
+	}
+	return c.Value.Inspect()
+}
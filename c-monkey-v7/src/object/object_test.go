@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -32,3 +36,85 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("string wtih different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 1.5}).HashKey() != (&Float{Value: 1.5}).HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("whole float and equal integer have different hash keys")
+	}
+
+	if (&Float{Value: math.Copysign(0, -1)}).HashKey() != (&Integer{Value: 0}).HashKey() {
+		t.Errorf("negative zero and 0 have different hash keys")
+	}
+
+	if (&Float{Value: 1e300}).HashKey() == (&Integer{Value: math.MaxInt64}).HashKey() {
+		t.Errorf("float outside the integer range has the hash key of an integer")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-3, "-3.0"},
+		{0.1, "0.1"},
+		{1e-7, "1e-07"},
+		{1e21, "1e+21"},
+		{100000, "100000.0"},
+		{123456789, "1.23456789e+08"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect for %v. want=%q, got=%q", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestBigIntegerHashKey(t *testing.T) {
+	twoTo64, _ := new(big.Int).SetString("18446744073709551616", 10)
+	big1 := &BigInteger{Value: twoTo64}
+	big2 := &BigInteger{Value: new(big.Int).Set(twoTo64)}
+
+	if big1.HashKey() != big2.HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+
+	if big1.HashKey() == (&BigInteger{Value: new(big.Int).Neg(twoTo64)}).HashKey() {
+		t.Errorf("big integers with different values have same hash keys")
+	}
+
+	if big1.HashKey() != (&Float{Value: math.Pow(2, 64)}).HashKey() {
+		t.Errorf("whole float and equal big integer have different hash keys")
+	}
+}
+
+func TestUncaughtMessage(t *testing.T) {
+	tests := []struct {
+		value    Object
+		expected string
+	}{
+		{&Integer{Value: 1}, "uncaught exception: 1"},
+		{&String{Value: "oops"}, "uncaught exception: oops"},
+		{&Exception{Message: "division by zero", Trace: []string{"<main> (1:3)"}}, "division by zero"},
+		{&Hash{Pairs: map[HashKey]HashPair{}}, "uncaught exception: {}"},
+	}
+
+	for _, tt := range tests {
+		if got := UncaughtMessage(tt.value); got != tt.expected {
+			t.Errorf("wrong message for %s. want=%q, got=%q", tt.value.Inspect(), tt.expected, got)
+		}
+	}
+}
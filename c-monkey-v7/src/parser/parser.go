@@ -2,9 +2,12 @@ package parser
 
 import (
 	"Compiler/c-monkey-v7/src/ast"
+	"Compiler/c-monkey-v7/src/diagnostic"
 	"Compiler/c-monkey-v7/src/lexer"
 	"Compiler/c-monkey-v7/src/token"
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 )
 
@@ -19,28 +22,68 @@ const (
 	// Gives incrementing numbers as values to the constants. The ordering matter.
 	// It is in the increasing order of precedence, ie, highest precedence at the bottom
 	LOWEST
+	ASSIGNMENT  // = or +=
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
 	LESSGREATER // > OR <
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	POWER       // **, binds tighter than a prefix on its left so that -2 ** 2 is -(2 ** 2)
 	CALL        // function call
 	INDEX       // array[index]
 )
 
+// Tokens that end or separate constructs, which an expression never starts with
+var closingTokens = map[token.TokenType]bool{
+	token.SEMICOLON: true,
+	token.COMMA:     true,
+	token.COLON:     true,
+	token.RPAREN:    true,
+	token.RBRACKET:  true,
+	token.RBRACE:    true,
+}
+
+// Tokens that can only start a statement, so error recovery stops in front of them
+var statementKeywords = map[token.TokenType]bool{
+	token.LET:      true,
+	token.RETURN:   true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+	token.TRY:      true,
+	token.THROW:    true,
+}
+
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT_EQ:    EQUALS,
-	token.GT_EQ:    EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGNMENT,
+	token.PLUS_ASSIGN:     ASSIGNMENT,
+	token.MINUS_ASSIGN:    ASSIGNMENT,
+	token.ASTERISK_ASSIGN: ASSIGNMENT,
+	token.SLASH_ASSIGN:    ASSIGNMENT,
+	token.OR:              LOGICAL_OR,
+	token.AND:             LOGICAL_AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT_EQ:           EQUALS,
+	token.GT_EQ:           EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.PIPE:            SUM, // Bitwise operators share the levels of the arithmetic ones, like in Go
+	token.CARET:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.AMPERSAND:       PRODUCT,
+	token.SHIFT_LEFT:      PRODUCT,
+	token.SHIFT_RIGHT:     PRODUCT,
+	token.POWER:           POWER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 /*
@@ -54,7 +97,19 @@ type Parser struct {
 	l         *lexer.Lexer
 	curToken  token.Token
 	peekToken token.Token
-	errors    []string
+	prevToken token.Token  // Kept so that the parser can back up one token, see backUp
+	pending   *token.Token // The peekToken from before backing up, taken by the next nextToken
+
+	tokenIndex int  // Number of tokens moved past, so that backUp never goes back past the start of a statement
+	stmtStart  int  // tokenIndex of the first token of the innermost statement being parsed
+	braceDepth int  // { minus } up to and including curToken
+	stmtDepth  int  // braceDepth from before the first token of the innermost statement being parsed
+	panicking  bool // An error was reported in the current statement, so the errors that follow from it are not
+	// The lexer reported a comment or string running into the end of the input, which already says all there is
+	// to say about the EOF after it
+	unterminated bool
+
+	diagnostics []*diagnostic.Diagnostic
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -62,15 +117,17 @@ type Parser struct {
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []*diagnostic.Diagnostic{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBooleans)
 	p.registerPrefix(token.FALSE, p.parseBooleans)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
@@ -91,8 +148,22 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	// Read two tokens to set curToken and peekToken.
 	p.nextToken()
@@ -110,7 +181,14 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		bad := &ast.BadExpression{Token: p.curToken}
+
+		// A missing operand, ie, the } in "{ x + }". Leave the token to the construct it belongs to, so that the
+		// block still ends there
+		if closingTokens[p.curToken.Type] && p.tokenIndex > p.stmtStart && p.pending == nil {
+			p.backUp()
+		}
+		return bad
 	}
 	leftExp := prefix()
 
@@ -133,10 +211,29 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		if value, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			return &ast.BigIntegerLiteral{Token: p.curToken, Value: value}
+		}
+	}
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		p.addError(diagnostic.InvalidIntegerLiteral, p.curToken, msg)
+		return &ast.BadExpression{Token: p.curToken}
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
+		p.addError(diagnostic.InvalidFloatLiteral, p.curToken, msg)
+		return &ast.BadExpression{Token: p.curToken}
 	}
 
 	lit.Value = value
@@ -151,6 +248,9 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if array.Elements == nil {
+		return &ast.BadExpression{Token: array.Token}
+	}
 
 	return array
 }
@@ -202,6 +302,9 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	if precedence == POWER { // Right associative, so 2 ** 3 ** 2 is 2 ** (3 ** 2)
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
@@ -209,12 +312,13 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	lparen := p.curToken
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return &ast.BadExpression{Token: lparen}
 	}
 
 	return exp
@@ -224,18 +328,18 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return &ast.BadExpression{Token: expression.Token}
 	}
 
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return &ast.BadExpression{Token: expression.Token}
 	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return &ast.BadExpression{Token: expression.Token}
 	}
 
 	expression.Consequence = p.parseBlockStatement()
@@ -244,7 +348,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		p.nextToken()
 
 		if !p.expectPeek(token.LBRACE) {
-			return nil
+			return &ast.BadExpression{Token: expression.Token}
 		}
 
 		expression.Alternative = p.parseBlockStatement()
@@ -259,10 +363,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
-		}
+		block.Statements = append(block.Statements, p.parseStatement())
 		p.nextToken()
 	}
 
@@ -273,13 +374,13 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return &ast.BadExpression{Token: lit.Token}
 	}
 
 	lit.Parameters = p.parseFunctionParameters()
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return &ast.BadExpression{Token: lit.Token}
 	}
 
 	lit.Body = p.parseBlockStatement()
@@ -316,6 +417,9 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	if exp.Arguments == nil {
+		return &ast.BadExpression{Token: exp.Token}
+	}
 	return exp
 }
 
@@ -326,12 +430,30 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp.Index = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RBRACKET) {
-		return nil
+		return &ast.BadExpression{Token: exp.Token}
 	}
 
 	return exp
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{Token: p.curToken, Target: target, Operator: p.curToken.Literal}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case *ast.BadExpression: // The target already failed to parse and has its own error
+		return target
+	default:
+		p.addError(diagnostic.InvalidAssignTarget, p.curToken, fmt.Sprintf("cannot assign to %s", target.String()))
+		return &ast.BadExpression{Token: exp.Token}
+	}
+
+	p.nextToken()
+	exp.Value = p.parseExpression(LOWEST) // Not ASSIGNMENT, so that a = b = c is right associative, ie, a = (b = c)
+
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
@@ -341,7 +463,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		key := p.parseExpression(LOWEST)
 
 		if !p.expectPeek(token.COLON) {
-			return nil
+			return &ast.BadExpression{Token: hash.Token}
 		}
 
 		p.nextToken()
@@ -350,24 +472,45 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		hash.Pairs[key] = value
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
-			return nil
+			return &ast.BadExpression{Token: hash.Token}
 		}
 	}
 
 	if !p.expectPeek(token.RBRACE) {
-		return nil
+		return &ast.BadExpression{Token: hash.Token}
 	}
 
 	return hash
 }
 
+// Errors as "line:column: message" strings. Use Diagnostics() for the structured version
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		errors = append(errors, d.Error())
+	}
+	return errors
+}
+
+func (p *Parser) Diagnostics() []*diagnostic.Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("Expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(diagnostic.UnexpectedToken, p.peekToken, msg)
+}
+
+// Every error points at the token that caused it. Only the first error in a statement is reported, as the ones
+// after it mostly follow from it, and none is reported at an EOF that an unterminated comment or string ran into
+func (p *Parser) addError(code diagnostic.Code, tok token.Token, msg string) {
+	if p.panicking || (tok.Type == token.EOF && p.unterminated) {
+		return
+	}
+	p.panicking = true
+
+	d := &diagnostic.Diagnostic{Severity: diagnostic.Error, Code: code, Span: diagnostic.TokenSpan(tok), Message: msg}
+	p.diagnostics = append(p.diagnostics, d)
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -387,8 +530,59 @@ func (p *Parser) curPrecedence() int {
 }
 
 func (p *Parser) nextToken() {
+	p.prevToken = p.curToken
 	p.curToken = p.peekToken
+	p.tokenIndex++
+	p.braceDepth += braceDelta(p.curToken)
+
+	if p.pending != nil {
+		p.peekToken = *p.pending
+		p.pending = nil
+		return
+	}
+
 	p.peekToken = p.l.NextToken()
+	for _, d := range p.l.TakeDiagnostics() { // Bad strings are reported as the lexer reads them
+		if d.Code == diagnostic.UnterminatedComment || d.Code == diagnostic.UnterminatedString {
+			p.unterminated = true
+		}
+		p.diagnostics = append(p.diagnostics, d)
+	}
+}
+
+// Steps back to the previous token. Only one token of history is kept, so it cannot be called twice in a row
+func (p *Parser) backUp() {
+	peek := p.peekToken
+	p.pending = &peek
+	p.braceDepth -= braceDelta(p.curToken)
+	p.peekToken = p.curToken
+	p.curToken = p.prevToken
+	p.tokenIndex--
+}
+
+func braceDelta(tok token.Token) int {
+	switch tok.Type {
+	case token.LBRACE:
+		return 1
+	case token.RBRACE:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// Skips the rest of a statement that had an error, up to its ; or up to just before a } or a statement keyword.
+// Braces the statement opened are skipped as a whole, so that a broken if or function does not leave its body behind
+// to be parsed as more statements. Leaves the parser on the last token skipped, like the statement parsers do
+func (p *Parser) synchronize() {
+	for !p.curTokenIs(token.EOF) {
+		if p.braceDepth <= p.stmtDepth {
+			if p.curTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) || statementKeywords[p.peekToken.Type] {
+				return
+			}
+		}
+		p.nextToken()
+	}
 }
 
 func (p *Parser) curTokenIs(tokType token.TokenType) bool {
@@ -419,18 +613,213 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
+// Never returns nil. A statement that fails to parse is skipped up to the next synchronization point, a ; or a } or
+// a statement keyword, and is replaced by an ast.BadStatement
 func (p *Parser) parseStatement() ast.Statement {
-	// This method returns an actual Statement struct but parseLetStatement()
-	// returns a pointer. Why? What is the convention?
+	start := p.curToken
+	outerStart, outerDepth, outerPanicking := p.stmtStart, p.stmtDepth, p.panicking
+	p.stmtStart, p.stmtDepth, p.panicking = p.tokenIndex, p.braceDepth-braceDelta(start), false
 
+	stmt := p.parseStatementKind()
+	if p.panicking {
+		p.synchronize()
+	}
+	p.stmtStart, p.stmtDepth, p.panicking = outerStart, outerDepth, outerPanicking
+
+	if stmt == nil {
+		return &ast.BadStatement{Token: start, End: diagnostic.TokenSpan(p.curToken).End}
+	}
+	return stmt
+}
+
+// The statement parsers return nil pointers on errors, which have to be checked before they become non-nil
+// ast.Statement values
+func (p *Parser) parseStatementKind() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
 	case token.RETURN:
-		return p.parseReturnStatement()
+		if stmt := p.parseReturnStatement(); stmt != nil {
+			return stmt
+		}
+	case token.WHILE:
+		if stmt := p.parseWhileStatement(); stmt != nil {
+			return stmt
+		}
+	case token.FOR:
+		if stmt := p.parseForStatement(); stmt != nil {
+			return stmt
+		}
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.TRY:
+		if stmt := p.parseTryStatement(); stmt != nil {
+			return stmt
+		}
+	case token.THROW:
+		return p.parseThrowStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
+
+	return nil
+}
+
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.SEMICOLON) { // Optional, like after an if expression
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseTryStatement() *ast.TryStatement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.CatchName = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Finally = p.parseBlockStatement()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.addError(diagnostic.MissingHandler, stmt.Token, "try without catch or finally")
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
@@ -449,7 +838,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok { // Let the function know what it is bound to so that it can call itself
+		fl.Name = stmt.Name.Value
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -462,7 +855,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	p.nextToken()
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -483,7 +876,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(diagnostic.NoPrefixParseFn, p.curToken, msg)
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -491,12 +884,10 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
-		}
+		program.Statements = append(program.Statements, p.parseStatement())
 		p.nextToken()
 	}
+	program.Comments = p.curToken.Comments
 
 	return program
 }
@@ -2,7 +2,9 @@ package parser
 
 import (
 	"Compiler/c-monkey-v7/src/ast"
+	"Compiler/c-monkey-v7/src/diagnostic"
 	"Compiler/c-monkey-v7/src/lexer"
+	"Compiler/c-monkey-v7/src/token"
 	"fmt"
 	"testing"
 )
@@ -280,6 +282,66 @@ func TestIntegerLiteral(t *testing.T) {
 	}
 }
 
+func TestBigIntegerLiteral(t *testing.T) {
+	input := "18446744073709551616;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.BigIntegerLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.BigIntegerLiteral. got=%T", stmt.Expression)
+	}
+
+	if literal.Value.String() != "18446744073709551616" {
+		t.Errorf("literal.Value not %s. got=%s", "18446744073709551616", literal.Value)
+	}
+
+	if literal.String() != "18446744073709551616" {
+		t.Errorf("literal.String() not %s. got=%s", "18446744073709551616", literal.String())
+	}
+}
+
+func TestFloatLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5;", 1.5},
+		{"0.001", 0.001},
+		{"1e-3", 0.001},
+		{"2.5E+2", 250},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestBooleans(t *testing.T) {
 	input := "false;"
 
@@ -321,6 +383,7 @@ func TestParsingPrefixExpressions(t *testing.T) {
 		{"-15", "-", 15},
 		{"!true;", "!", true},
 		{"!false;", "!", false},
+		{"~7", "~", 7},
 	}
 
 	for _, tt := range prefixTests {
@@ -373,6 +436,15 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"true == true", true, "==", true},
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
+		{"5 % 5", 5, "%", 5},
+		{"5 ** 5", 5, "**", 5},
+		{"5 & 5", 5, "&", 5},
+		{"5 | 5", 5, "|", 5},
+		{"5 ^ 5", 5, "^", 5},
+		{"5 << 5", 5, "<<", 5},
+		{"5 >> 5", 5, ">>", 5},
+		{"true && false", true, "&&", false},
+		{"false || true", false, "||", true},
 	}
 
 	for _, tt := range infixTests {
@@ -414,6 +486,58 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		input    string
 		expected string
 	}{
+		{
+			"x = y + 1 == 2",
+			"(x = ((y + 1) == 2))",
+		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2 * 3",
+			"((-(2 ** 2)) * 3)",
+		},
+		{
+			"2 ** -1",
+			"(2 ** (-1))",
+		},
+		{
+			"a | b ^ c & d << 1",
+			"((a | b) ^ ((c & d) << 1))",
+		},
+		{
+			"~a & b == c >> 2",
+			"(((~a) & b) == (c >> 2))",
+		},
+		{
+			"a[0] ** f(x)",
+			"((a[0]) ** f(x))",
+		},
+		{
+			"a || b && c || d",
+			"((a || (b && c)) || d)",
+		},
+		{
+			"a < b && c != d || !e",
+			"(((a < b) && (c != d)) || (!e))",
+		},
+		{
+			"x = a && b",
+			"(x = (a && b))",
+		},
+		{
+			"a = b += c * 2",
+			"(a = (b += (c * 2)))",
+		},
+		{
+			"a[i + 1] -= f(x)[0]",
+			"((a[(i + 1)]) -= (f(x)[0]))",
+		},
 		{
 			"a + add(b * c) + d",
 			"((a + add((b * c))) + d)",
@@ -638,6 +762,319 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { if (x) { break; } continue }; x`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 2, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T", program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	ifStmt, ok := stmt.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("body.Statements[0] is not ast.ExpressionStatement. got=%T", stmt.Body.Statements[0])
+	}
+
+	ifExp, ok := ifStmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("body.Statements[0] is not ast.IfExpression. got=%T", ifStmt.Expression)
+	}
+
+	if _, ok := ifExp.Consequence.Statements[0].(*ast.BreakStatement); !ok {
+		t.Fatalf("consequence.Statements[0] is not ast.BreakStatement. got=%T", ifExp.Consequence.Statements[0])
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Fatalf("body.Statements[1] is not ast.ContinueStatement. got=%T", stmt.Body.Statements[1])
+	}
+}
+
+func TestWhileStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while x { x }", "1:7: Expected next token to be (, got IDENT instead"},
+		{"while (x { x }", "1:10: Expected next token to be ), got { instead"},
+		{"while (x) x", "1:11: Expected next token to be {, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong first error for %q. want=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedNames    []string
+		expectedIterable string
+		expectedBody     string
+	}{
+		{"for (x in [1, 2]) { puts(x) }", []string{"x"}, "[1, 2]", "puts(x)"},
+		{"for (k, v in h) { k + v; };", []string{"k", "v"}, "h", "(k + v)"},
+		{"for (c in first(words)) { break; }", []string{"c"}, "first(words)", "break;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T", program.Statements[0])
+		}
+
+		if len(stmt.Names) != len(tt.expectedNames) {
+			t.Fatalf("wrong number of names. want=%d, got=%d", len(tt.expectedNames), len(stmt.Names))
+		}
+		for i, name := range tt.expectedNames {
+			testIdentifier(t, stmt.Names[i], name)
+		}
+
+		if stmt.Iterable.String() != tt.expectedIterable {
+			t.Errorf("wrong iterable. want=%q, got=%q", tt.expectedIterable, stmt.Iterable.String())
+		}
+
+		if stmt.Body.String() != tt.expectedBody {
+			t.Errorf("wrong body. want=%q, got=%q", tt.expectedBody, stmt.Body.String())
+		}
+	}
+}
+
+func TestForStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for x in y { x }", "1:5: Expected next token to be (, got IDENT instead"},
+		{"for (1 in y) { x }", "1:6: Expected next token to be IDENT, got INT instead"},
+		{"for (x y) { x }", "1:8: Expected next token to be IN, got IDENT instead"},
+		{"for (k, v, w in y) { x }", "1:10: Expected next token to be IN, got , instead"},
+		{"for (x in y { x }", "1:13: Expected next token to be ), got { instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong first error for %q. want=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedBody    string
+		expectedName    string // Empty if there is no catch
+		expectedCatch   string
+		expectedFinally string // Empty if there is no finally
+	}{
+		{"try { f(x) } catch (e) { puts(e) }", "f(x)", "e", "puts(e)", ""},
+		{"try { x } finally { y };", "x", "", "", "y"},
+		{"try { x } catch (err) { y } finally { z }", "x", "err", "y", "z"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.TryStatement. got=%T", program.Statements[0])
+		}
+
+		if stmt.Body.String() != tt.expectedBody {
+			t.Errorf("wrong body. want=%q, got=%q", tt.expectedBody, stmt.Body.String())
+		}
+
+		if tt.expectedName == "" {
+			if stmt.Catch != nil {
+				t.Errorf("unexpected catch %q", stmt.Catch.String())
+			}
+		} else {
+			if !testIdentifier(t, stmt.CatchName, tt.expectedName) {
+				return
+			}
+			if stmt.Catch.String() != tt.expectedCatch {
+				t.Errorf("wrong catch. want=%q, got=%q", tt.expectedCatch, stmt.Catch.String())
+			}
+		}
+
+		if tt.expectedFinally == "" {
+			if stmt.Finally != nil {
+				t.Errorf("unexpected finally %q", stmt.Finally.String())
+			}
+		} else if stmt.Finally == nil || stmt.Finally.String() != tt.expectedFinally {
+			t.Errorf("wrong finally. want=%q, got=%v", tt.expectedFinally, stmt.Finally)
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	input := `throw "oops"; throw x + 1`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 2, len(program.Statements))
+	}
+
+	expected := []string{`throw oops;`, `throw (x + 1);`}
+	for i, s := range program.Statements {
+		stmt, ok := s.(*ast.ThrowStatement)
+		if !ok {
+			t.Fatalf("program.Statements[%d] is not ast.ThrowStatement. got=%T", i, s)
+		}
+		if stmt.String() != expected[i] {
+			t.Errorf("wrong statement. want=%q, got=%q", expected[i], stmt.String())
+		}
+	}
+}
+
+func TestTryStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { x }", "1:1: try without catch or finally"},
+		{"try x", "1:5: Expected next token to be {, got IDENT instead"},
+		{"try { x } catch e { }", "1:17: Expected next token to be (, got IDENT instead"},
+		{"try { x } catch (1) { }", "1:18: Expected next token to be IDENT, got INT instead"},
+		{"try { x } finally y", "1:19: Expected next token to be {, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong first error for %q. want=%q, got=%q", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedTarget   string
+		expectedOperator string
+		expectedValue    string
+	}{
+		{"x = 5;", "x", "=", "5"},
+		{"x += y", "x", "+=", "y"},
+		{"x -= 1", "x", "-=", "1"},
+		{"x *= 2", "x", "*=", "2"},
+		{"x /= 2", "x", "/=", "2"},
+		{`h["k"] = [1]`, "(h[k])", "=", "[1]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+
+		exp, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T", stmt.Expression)
+		}
+
+		if exp.Target.String() != tt.expectedTarget {
+			t.Errorf("wrong target. want=%q, got=%q", tt.expectedTarget, exp.Target.String())
+		}
+		if exp.Operator != tt.expectedOperator {
+			t.Errorf("wrong operator. want=%q, got=%q", tt.expectedOperator, exp.Operator)
+		}
+		if exp.Value.String() != tt.expectedValue {
+			t.Errorf("wrong value. want=%q, got=%q", tt.expectedValue, exp.Value.String())
+		}
+	}
+}
+
+func TestInvalidAssignTargets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 = 2", "1:3: cannot assign to 1"},
+		{"f() = 2", "1:5: cannot assign to f()"},
+		{"a + b += 2", "1:7: cannot assign to (a + b)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		if len(p.Diagnostics()) == 0 {
+			t.Fatalf("expected a parser error for %q, got none", tt.input)
+		}
+
+		d := p.Diagnostics()[0]
+		if d.Code != diagnostic.InvalidAssignTarget {
+			t.Errorf("wrong diagnostic code. want=%s, got=%s", diagnostic.InvalidAssignTarget, d.Code)
+		}
+		if d.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, d.Error())
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -762,6 +1199,38 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestComments(t *testing.T) {
+	input := `
+	// Adds one
+	let inc = fn(x) { x /* the argument */ + 1 };
+	inc(1) // Comments never end up as tokens
+	/* The end */`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if program.String() != "let inc = fn<inc>(x) (x + 1);inc(1)" {
+		t.Fatalf("program wrong. got=%q", program.String())
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	if len(let.Token.Comments) != 1 || let.Token.Comments[0].Text != "// Adds one" {
+		t.Errorf("let statement comments wrong. got=%+v", let.Token.Comments)
+	}
+
+	body := let.Value.(*ast.FunctionLiteral).Body.Statements[0].(*ast.ExpressionStatement)
+	infix := body.Expression.(*ast.InfixExpression)
+	if len(infix.Token.Comments) != 1 || infix.Token.Comments[0].Text != "/* the argument */" {
+		t.Errorf("infix expression comments wrong. got=%+v", infix.Token.Comments)
+	}
+
+	if len(program.Comments) != 2 || program.Comments[0].Text != "// Comments never end up as tokens" || program.Comments[1].Text != "/* The end */" {
+		t.Errorf("program comments wrong. got=%+v", program.Comments)
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := "[1, 2*2, 3+3]"
 
@@ -945,3 +1414,288 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not have %d statements, got=%d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T", program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q", function.Name)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+		expectedString string
+	}{
+		{
+			"let x 5; let y = 1;",
+			[]string{"1:7: Expected next token to be =, got INT instead"},
+			"<bad statement>let y = 1;",
+		},
+		{
+			"let x = 1 + ; let y = 2;",
+			[]string{"1:13: no prefix parse function for ; found"},
+			"let x = (1 + <bad expression>);let y = 2;",
+		},
+		{
+			"fn() { x + } ; y",
+			[]string{"1:12: no prefix parse function for } found"},
+			"fn() (x + <bad expression>)y",
+		},
+		{
+			"if (x { 1 } let y = 2;",
+			[]string{"1:7: Expected next token to be ), got { instead"},
+			"<bad expression>let y = 2;",
+		},
+		{
+			"f(1, , 3); g(",
+			[]string{"1:6: no prefix parse function for , found", "1:14: no prefix parse function for EOF found"},
+			"f(1, <bad expression>, 3)<bad expression>",
+		},
+		{
+			"{1 2}; x",
+			[]string{"1:4: Expected next token to be :, got INT instead"},
+			"<bad expression>x",
+		},
+		{
+			"let a = {1: }; } let b = [1 2];",
+			[]string{"1:13: no prefix parse function for } found", "1:16: no prefix parse function for } found", "1:29: Expected next token to be ], got INT instead"},
+			"let a = {1:<bad expression>};<bad expression>let b = <bad expression>;",
+		},
+		{
+			"while (true) { return 1 } return 2 }",
+			[]string{"1:36: no prefix parse function for } found"},
+			"whiletrue return 1;return 2;<bad expression>",
+		},
+		{
+			"let f = fn() { let = 1; return 2; }; f() + * 3",
+			[]string{"1:20: Expected next token to be IDENT, got = instead", "1:44: no prefix parse function for * found"},
+			"let f = fn<f>() <bad statement>return 2;;(f() + <bad expression>)",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("%q - wrong number of errors. want=%d, got=%d: %q", tt.input, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+		for i, expected := range tt.expectedErrors {
+			if errors[i] != expected {
+				t.Errorf("%q - error[%d] wrong. want=%q, got=%q", tt.input, i, expected, errors[i])
+			}
+		}
+
+		if program.String() != tt.expectedString {
+			t.Errorf("%q - program wrong. want=%q, got=%q", tt.input, tt.expectedString, program.String())
+		}
+	}
+}
+
+func TestOptionalSemicolons(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1", "let x = 1;"},
+		{"let f = fn() { return 1 }", "let f = fn<f>() return 1;;"},
+		{"fn() { let x = 1 return x }", "fn() let x = 1;return x;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("program wrong. want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestBadStatementSpan(t *testing.T) {
+	l := lexer.New("x;\nlet 5 + 1;\ny")
+	p := New(l)
+	program := p.ParseProgram()
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d", len(program.Statements))
+	}
+
+	bad, ok := program.Statements[1].(*ast.BadStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not ast.BadStatement. got=%T", program.Statements[1])
+	}
+
+	start := token.Position{Line: 2, Column: 1, Offset: 3}
+	end := token.Position{Line: 2, Column: 11, Offset: 13}
+	if bad.Pos() != start || bad.End != end {
+		t.Errorf("bad statement span wrong. want=%+v-%+v, got=%+v-%+v", start, end, bad.Pos(), bad.End)
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "1:7: Expected next token to be =, got INT instead"},
+		{"let x = 1;\nlet = 10;", "2:5: Expected next token to be IDENT, got = instead"},
+		{"1 +\n\n  ;", "3:3: no prefix parse function for ; found"},
+		{"if (x) {\n  x }\nelse y", "3:6: Expected next token to be {, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong first error for %q. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestUnterminatedInputErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let c = /* never closed", []string{"1:9: unterminated block comment"}},
+		{"let f = fn() { /* never closed", []string{"1:16: unterminated block comment"}},
+		{"puts(\"never closed", []string{"1:6: unterminated string"}},
+		{"let s = `never closed", []string{"1:9: unterminated raw string"}},
+		{"let c = 1 +", []string{"1:12: no prefix parse function for EOF found"}}, // Nothing was left open
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input, tt.expected, errors)
+			continue
+		}
+		for i, err := range errors {
+			if err != tt.expected[i] {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected[i], err)
+			}
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2][0]);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	function := let.Value.(*ast.FunctionLiteral)
+	body := function.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+
+	tests := []struct {
+		node         ast.Node
+		expectedLine int
+		expectedCol  int
+	}{
+		{program, 1, 1},
+		{let, 1, 1},
+		{let.Name, 1, 5},
+		{function, 1, 11},
+		{function.Parameters[1], 1, 17},
+		{function.Body, 1, 20},
+		{body, 2, 3},
+		{body.Expression, 2, 3}, // Infix expressions start at their left operand
+		{body.Expression.(*ast.InfixExpression).Right, 2, 7},
+		{call, 4, 1},
+		{call.Arguments[0], 4, 5},
+		{call.Arguments[1], 4, 8},
+		{call.Arguments[1].(*ast.IndexExpression).Index, 4, 12},
+	}
+
+	for i, tt := range tests {
+		pos := tt.node.Pos()
+		if pos.Line != tt.expectedLine || pos.Column != tt.expectedCol {
+			t.Errorf("test[%d] %q - wrong position. want=%d:%d, got=%d:%d", i, tt.node.String(), tt.expectedLine, tt.expectedCol, pos.Line, pos.Column)
+		}
+	}
+}
+
+func TestParserDiagnostics(t *testing.T) {
+	input := "let x 5;\nlet y = 09999999999999999999;\nlet z = 1e999;\nlet s = \"a\\qb\";\nputs(\"é"
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	expected := []struct {
+		code  diagnostic.Code
+		start token.Position
+		end   token.Position
+	}{
+		{diagnostic.UnexpectedToken, token.Position{Line: 1, Column: 7, Offset: 6}, token.Position{Line: 1, Column: 8, Offset: 7}},
+		{diagnostic.InvalidIntegerLiteral, token.Position{Line: 2, Column: 9, Offset: 17}, token.Position{Line: 2, Column: 29, Offset: 37}},
+		{diagnostic.InvalidFloatLiteral, token.Position{Line: 3, Column: 9, Offset: 47}, token.Position{Line: 3, Column: 14, Offset: 52}},
+		{diagnostic.InvalidEscape, token.Position{Line: 4, Column: 11, Offset: 64}, token.Position{Line: 4, Column: 13, Offset: 66}},
+		{diagnostic.UnterminatedString, token.Position{Line: 5, Column: 6, Offset: 75}, token.Position{Line: 5, Column: 9, Offset: 78}},
+	}
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("wrong number of diagnostics. want=%d, got=%d (%v)", len(expected), len(diagnostics), p.Errors())
+	}
+
+	for i, tt := range expected {
+		d := diagnostics[i]
+		if d.Severity != diagnostic.Error {
+			t.Errorf("diagnostic[%d] - wrong severity. got=%s", i, d.Severity)
+		}
+		if d.Code != tt.code {
+			t.Errorf("diagnostic[%d] - wrong code. want=%s, got=%s", i, tt.code, d.Code)
+		}
+		if d.Span.Start != tt.start || d.Span.End != tt.end {
+			t.Errorf("diagnostic[%d] - wrong span. want=%+v-%+v, got=%+v-%+v", i, tt.start, tt.end, d.Span.Start, d.Span.End)
+		}
+	}
+}
@@ -2,23 +2,33 @@ package repl
 
 import (
 	"Compiler/c-monkey-v7/src/compiler"
+	"Compiler/c-monkey-v7/src/diagnostic"
+	"Compiler/c-monkey-v7/src/evaluator"
 	"Compiler/c-monkey-v7/src/lexer"
 	"Compiler/c-monkey-v7/src/object"
 	"Compiler/c-monkey-v7/src/parser"
 	"Compiler/c-monkey-v7/src/vm"
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 )
 
 const PROMPT = ">>"
 
+// The constant pool is compacted once it has grown to this many constants, and then again every time it doubles
+const compactAt = 1024
+
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 
 	constants := []object.Object{}
+	nextCompaction := compactAt
 	globals := make([]object.Object, vm.GlobalsSize)
 	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
 
 	for {
 		fmt.Fprint(out, PROMPT)
@@ -32,15 +42,25 @@ func Start(in io.Reader, out io.Writer) {
 		p := parser.New(l)
 
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
+		if len(p.Diagnostics()) != 0 {
+			printDiagnostics(out, line, p.Diagnostics())
 			continue
 		}
 
+		if len(constants) >= nextCompaction { // Nothing is running between lines, so the pool can be renumbered
+			constants = compiler.CompactConstants(constants)
+			nextCompaction = max(2*len(constants), compactAt)
+		}
+
 		comp := compiler.NewWithState(symbolTable, constants)
 		err := comp.Compile(program)
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+			var d *diagnostic.Diagnostic
+			if errors.As(err, &d) {
+				printDiagnostics(out, line, []*diagnostic.Diagnostic{d})
+			} else {
+				fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+			}
 			continue
 		}
 
@@ -50,19 +70,56 @@ func Start(in io.Reader, out io.Writer) {
 		machine := vm.NewWithGlobalStore(code, globals)
 		err = machine.Run()
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			var rtErr *vm.RuntimeError
+			if errors.As(err, &rtErr) {
+				io.WriteString(out, rtErr.StackTrace())
+			} else {
+				fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			}
 			continue
 		}
 
 		lastPopped := machine.LastPoppedStackElem()
+		if lastPopped == nil { // Nothing was run, ie, an empty or comment-only line
+			continue
+		}
 		io.WriteString(out, lastPopped.Inspect())
 		io.WriteString(out, "\n")
 	}
 }
 
-func printParseErrors(out io.Writer, errors []string) {
-	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+// StartEvaluator is the same loop as Start, but walks the AST with the tree-walking evaluator instead of compiling it
+func StartEvaluator(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	env := object.NewEnvironment()
+
+	for {
+		fmt.Fprint(out, PROMPT)
+		scanned := scanner.Scan()
+		if !scanned {
+			return
+		}
+
+		line := scanner.Text()
+		l := lexer.New(line)
+		p := parser.New(l)
+
+		program := p.ParseProgram()
+		if len(p.Diagnostics()) != 0 {
+			printDiagnostics(out, line, p.Diagnostics())
+			continue
+		}
+
+		evaluated := evaluator.Eval(program, env)
+		if evaluated != nil {
+			io.WriteString(out, evaluated.Inspect())
+			io.WriteString(out, "\n")
+		}
+	}
+}
+
+func printDiagnostics(out io.Writer, source string, diagnostics []*diagnostic.Diagnostic) {
+	for _, d := range diagnostics {
+		diagnostic.Render(out, source, d)
 	}
 }
@@ -0,0 +1,22 @@
+package repl
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBlankLines(t *testing.T) {
+	input := "\n// just a comment\n/* a block comment */\n   \n1 + 1\n"
+	expected := PROMPT + PROMPT + PROMPT + PROMPT + PROMPT + "2\n" + PROMPT
+
+	starts := map[string]func(io.Reader, io.Writer){"vm": Start, "eval": StartEvaluator}
+	for engine, start := range starts {
+		var out strings.Builder
+		start(strings.NewReader(input), &out)
+
+		if out.String() != expected {
+			t.Errorf("%s: wrong output. want=%q, got=%q", engine, expected, out.String())
+		}
+	}
+}
@@ -1,10 +1,47 @@
 package token
 
+import "fmt"
+
 type TokenType string //TokenType is just an alias for string
 
 type Token struct {
-	Type    TokenType
-	Literal string
+	Type     TokenType
+	Literal  string
+	Pos      Position  // Where the token starts in the source
+	Comments []Comment // Comments between the previous token and this one, nil if there are none
+}
+
+// Comments are not tokens of their own, the parser never sees them. They are kept on the token that follows them,
+// so that they can be got back from the AST, ie, by a formatter or a doc generator
+type Comment struct {
+	Text string // Including the delimiters, ie, "// note" or "/* note */"
+	Pos  Position
+}
+
+type Position struct {
+	File   string // Name of the source file, empty for input that does not come from a file (REPL, tests)
+	Line   int    // Starts at 1
+	Column int    // Starts at 1, counted in bytes from the start of the line
+	Offset int    // Byte offset from the start of the input, starts at 0
+}
+
+// A zero Position means the location is unknown, ie, the node or token was not created by the lexer
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.File != "" {
+			return p.File
+		}
+		return "-"
+	}
+
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 const (
@@ -14,6 +51,7 @@ const (
 	// Identifiers + literals
 	IDENT  = "IDENT" // add, foobar, x, y, ...
 	INT    = "INT"   // 1343456
+	FLOAT  = "FLOAT" // 1.5, 2e10, 1.5e-3
 	STRING = "STRING"
 
 	// Operators
@@ -23,6 +61,20 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+	POWER    = "**"
+
+	AMPERSAND   = "&"
+	PIPE        = "|"
+	CARET       = "^"
+	TILDE       = "~"
+	SHIFT_LEFT  = "<<"
+	SHIFT_RIGHT = ">>"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
 
 	LT = "<"
 	GT = ">"
@@ -32,6 +84,9 @@ const (
 	LT_EQ  = "<="
 	GT_EQ  = ">="
 
+	AND = "&&"
+	OR  = "||"
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -51,16 +106,34 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	FOR      = "FOR"
+	IN       = "IN"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"for":      FOR,
+	"in":       IN,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 func LookUpIdent(ident string) TokenType { // Basically return keyword type, if it is a keyword, else return IDENT
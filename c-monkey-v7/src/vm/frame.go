@@ -6,15 +6,15 @@ import (
 )
 
 type Frame struct {
-	fn          *object.CompiledFunction
-	ip          int // The instruction pointer within this particular frame
-	basePointer int // The position of the stack pointer before the execution of the function
+	cl          *object.Closure // The closure being executed. Its Fn holds the instructions, its Free holds the captured values
+	ip          int             // The instruction pointer within this particular frame
+	basePointer int             // The position of the stack pointer before the execution of the function
 }
 
-func NewFrame(fn *object.CompiledFunction, basePointer int) *Frame {
-	return &Frame{fn: fn, ip: -1, basePointer: basePointer}
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
 }
 
 func (f *Frame) Instructions() code.Instructions {
-	return f.fn.Instructions
+	return f.cl.Fn.Instructions
 }
@@ -0,0 +1,111 @@
+package vm
+
+import (
+	"Compiler/c-monkey-v7/src/code"
+	"Compiler/c-monkey-v7/src/object"
+	"Compiler/c-monkey-v7/src/token"
+	"bytes"
+	"fmt"
+)
+
+// Every error returned by Run is a RuntimeError, which points at the source that was executing when it happened
+type RuntimeError struct {
+	Message string
+	Pos     token.Position // Zero if the bytecode was compiled without positions
+	Trace   []StackFrame   // Innermost call first, main last
+}
+
+// One function call that was active when the error happened
+type StackFrame struct {
+	Function string         // Name of the function, see functionName
+	Offset   int            // Offset of the opcode of the instruction the frame was executing
+	Pos      token.Position // Source of that instruction, zero if unknown
+}
+
+// The frame as it appears in the trace of a caught error, ie, "add (2:5)"
+func (f StackFrame) String() string {
+	if f.Pos.IsValid() {
+		return fmt.Sprintf("%s (%s)", f.Function, f.Pos)
+	}
+	return f.Function
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+	}
+	return e.Message
+}
+
+/*
+StackTrace renders the error along with every active call, ie:
+
+	runtime error: type mismatch: INTEGER + STRING
+		at add (2:5, offset 4)
+		at <main> (4:4, offset 9)
+*/
+func (e *RuntimeError) StackTrace() string {
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "runtime error: %s\n", e.Message)
+	for _, f := range e.Trace {
+		if f.Pos.IsValid() {
+			fmt.Fprintf(&out, "\tat %s (%s, offset %d)\n", f.Function, f.Pos, f.Offset)
+		} else {
+			fmt.Fprintf(&out, "\tat %s (offset %d)\n", f.Function, f.Offset)
+		}
+	}
+
+	return out.String()
+}
+
+// Locate the error using the instruction every active frame stopped at. Frames below the current one are stopped
+// at the OpCall that created the frame above them
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	trace := make([]StackFrame, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		fn := frame.cl.Fn
+		offset := instructionStart(fn.Instructions, frame.ip)
+		pos, _ := fn.SourceMap.PositionFor(offset)
+
+		trace = append(trace, StackFrame{Function: functionName(fn, i), Offset: offset, Pos: pos})
+	}
+
+	return &RuntimeError{Message: err.Error(), Pos: trace[0].Pos, Trace: trace}
+}
+
+// The ip of a frame is left on the last operand of the instruction being executed, so walk the instructions to find
+// where that instruction starts
+func instructionStart(ins code.Instructions, ip int) int {
+	start := 0
+	for i := 0; i < len(ins) && i <= ip; {
+		start = i
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return ip
+		}
+
+		width := 1
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		i += width
+	}
+
+	return start
+}
+
+// The main program is always the bottom frame. Functions are named after the let they were bound to, if any
+func functionName(fn *object.CompiledFunction, frameIndex int) string {
+	switch {
+	case frameIndex == 0:
+		return "<main>"
+	case fn.Name != "":
+		return fn.Name
+	default:
+		return "<anonymous>"
+	}
+}
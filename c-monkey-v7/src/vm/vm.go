@@ -5,6 +5,7 @@ import (
 	"Compiler/c-monkey-v7/src/compiler"
 	"Compiler/c-monkey-v7/src/object"
 	"fmt"
+	"math"
 )
 
 const StackSize = 2048
@@ -43,8 +44,10 @@ func (vm *VM) popFrame() *Frame {
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions} // Treating main() as a function on its own
-	mainFrame := NewFrame(mainFn, 0)                                        // Creating a function for main
+	// Treating main() as a function on its own
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, SourceMap: bytecode.SourceMap, Handlers: bytecode.Handlers}
+	mainClosure := &object.Closure{Fn: mainFn} // Every frame executes a closure, main included
+	mainFrame := NewFrame(mainClosure, 0)      // Creating a function for main
 
 	frames := make([]*Frame, MaxFrames) // Creating a frame for the main
 	frames[0] = mainFrame               // Main function is the first frame
@@ -73,7 +76,76 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
-func (vm *VM) Run() error {
+// Run never panics on a bad program. A Go panic while executing, which would be a bug in the VM, is turned into a
+// RuntimeError at the instruction that caused it like any other error, except that a try can't catch it
+func (vm *VM) Run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.newRuntimeError(fmt.Errorf("internal error: %v", r))
+		}
+	}()
+
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		rtErr := vm.newRuntimeError(err)
+		if !vm.catch(exceptionValue(err, rtErr)) {
+			return rtErr
+		}
+	}
+}
+
+// The error of a throw, which carries the thrown value
+type thrownError struct {
+	value object.Object
+}
+
+func (e *thrownError) Error() string {
+	return object.UncaughtMessage(e.value)
+}
+
+// What a catch receives for err
+func exceptionValue(err error, rtErr *RuntimeError) object.Object {
+	if thrown, ok := err.(*thrownError); ok {
+		return thrown.value
+	}
+
+	trace := []string{}
+	for _, f := range rtErr.Trace {
+		trace = append(trace, f.String())
+	}
+	return &object.Exception{Message: rtErr.Message, Trace: trace}
+}
+
+// Unwind to the innermost handler of the instruction that failed, in its own frame or in one of the frames that
+// called it. The handler resumes with the stack cut back to its depth and the exception on top. false if nothing
+// handles it, in which case the frames are left as they are
+func (vm *VM) catch(exception object.Object) bool {
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		fn := frame.cl.Fn
+
+		handler, ok := fn.Handlers.HandlerFor(instructionStart(fn.Instructions, frame.ip))
+		if !ok {
+			continue
+		}
+
+		vm.framesIndex = i + 1
+		vm.sp = frame.basePointer + fn.NumLocals + handler.StackDepth
+		frame.ip = handler.Handler - 1 // The loop in run increments before fetching
+
+		vm.stack[vm.sp] = exception
+		vm.sp++
+		return true
+	}
+
+	return false
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -94,7 +166,8 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpAdd, code.OpSub, code.OpDiv, code.OpMul:
+		case code.OpAdd, code.OpSub, code.OpDiv, code.OpMul, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -115,7 +188,8 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual, code.OpLessThan,
+			code.OpLessThanOrEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -133,6 +207,12 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpBitNot:
+			err := vm.executeBitNotOperator()
+			if err != nil {
+				return err
+			}
+
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:])) // Read the operand right next to the OpCode
 			vm.currentFrame().ip = pos - 1          // set instruction pointer to the target of our jump. We do -1 so that the increment of the for loop can actually get us to the target
@@ -146,6 +226,17 @@ func (vm *VM) Run() error {
 				vm.currentFrame().ip = pos - 1
 			}
 
+		case code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			// The left operand decides the result on its own, so it stays on the stack and the right one is skipped
+			if isTruthy(vm.stack[vm.sp-1]) == (op == code.OpJumpTruthyOrPop) {
+				vm.currentFrame().ip = pos - 1
+			} else {
+				vm.pop()
+			}
+
 		case code.OpNull:
 			err := vm.push(Null)
 			if err != nil {
@@ -162,7 +253,7 @@ func (vm *VM) Run() error {
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.push(orNull(vm.globals[globalIndex])) // A hoisted function may be read before it is bound
 			if err != nil {
 				return err
 			}
@@ -236,8 +327,13 @@ func (vm *VM) Run() error {
 			localIndex := code.ReadUint8(ins[ip+1:]) // Read operand, ie, the index in this case
 			vm.currentFrame().ip += 1                // Increment to not read operand in the next cycle
 
-			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop() // Assign value in the stack with index as the offset
+			vm.setLocal(int(localIndex), vm.pop()) // Assign value in the stack with index as the offset
+
+		case code.OpSetLocalKeep:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			vm.setLocal(int(localIndex), vm.stack[vm.sp-1]) // The value stays on top of the stack
 
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:]) // Read operand
@@ -245,7 +341,126 @@ func (vm *VM) Run() error {
 
 			frame := vm.currentFrame()
 
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			err := vm.push(orNull(cellValue(vm.stack[frame.basePointer+int(localIndex)])))
+			if err != nil {
+				return err
+			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			slot := &vm.stack[vm.currentFrame().basePointer+int(localIndex)]
+			cell, ok := (*slot).(*object.Cell)
+			if !ok { // Captured for the first time, from now on the frame reads and writes the variable through the cell
+				cell = &object.Cell{Value: *slot}
+				*slot = cell
+			}
+
+			err := vm.push(cell)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.push(vm.currentFrame().cl.Free[freeIndex]) // Already a cell, which the new closure shares
+			if err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:]) // Index of the CompiledFunction in the constant pool
+			numFree := code.ReadUint8(ins[ip+3:])     // Number of free variables sitting on the stack
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(orNull(cellValue(currentClosure.Free[freeIndex])))
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell, ok := vm.currentFrame().cl.Free[freeIndex].(*object.Cell)
+			if !ok {
+				return fmt.Errorf("cannot assign to free variable %d, it is not a captured variable", freeIndex)
+			}
+			cell.Value = vm.pop()
+
+		case code.OpIter:
+			iterator, err := object.NewIterator(vm.pop())
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(iterator)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCompoundSetIndex:
+			op := code.Opcode(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeCompoundSetIndex(left, index, value, op)
+			if err != nil {
+				return err
+			}
+
+		case code.OpThrow:
+			return &thrownError{value: vm.pop()}
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			numValues := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.executeIterNext(pos, int(numValues))
 			if err != nil {
 				return err
 			}
@@ -255,6 +470,37 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// The iterator stays on the stack below the values it yields, and is only popped once it is exhausted
+func (vm *VM) executeIterNext(pos, numValues int) error {
+	iterator, ok := vm.stack[vm.sp-1].(*object.Iterator)
+	if !ok {
+		return fmt.Errorf("not an iterator: %s", vm.stack[vm.sp-1].Type())
+	}
+
+	if numValues == 1 {
+		element, ok := iterator.NextElement()
+		if !ok {
+			vm.pop()
+			vm.currentFrame().ip = pos - 1
+			return nil
+		}
+		return vm.push(element)
+	}
+
+	key, value, ok := iterator.Next()
+	if !ok {
+		vm.pop()
+		vm.currentFrame().ip = pos - 1
+		return nil
+	}
+
+	err := vm.push(key)
+	if err != nil {
+		return err
+	}
+	return vm.push(value)
+}
+
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
 		return fmt.Errorf("stack overflow")
@@ -293,18 +539,72 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right): // At least one is a float, so both are used as floats
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
+	case leftType != rightType:
+		return fmt.Errorf("type mismatch: %s %s %s", leftType, operatorSymbol(op), rightType)
 	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+		return unknownOperator(op, left, right)
+	}
+}
+
+// The source operators of the binary opcodes, so errors name them like the evaluator does
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:                "+",
+	code.OpSub:                "-",
+	code.OpMul:                "*",
+	code.OpDiv:                "/",
+	code.OpMod:                "%",
+	code.OpPow:                "**",
+	code.OpBitAnd:             "&",
+	code.OpBitOr:              "|",
+	code.OpBitXor:             "^",
+	code.OpShiftLeft:          "<<",
+	code.OpShiftRight:         ">>",
+	code.OpEqual:              "==",
+	code.OpNotEqual:           "!=",
+	code.OpGreaterThan:        ">",
+	code.OpGreaterThanOrEqual: ">=",
+	code.OpLessThan:           "<",
+	code.OpLessThanOrEqual:    "<=",
+}
+
+// The source operator of op, or the name of the opcode if it isn't a binary operator
+func operatorSymbol(op code.Opcode) string {
+	if operator, ok := binaryOperators[op]; ok {
+		return operator
+	}
+	if def, err := code.Lookup(byte(op)); err == nil {
+		return def.Name
 	}
+	return fmt.Sprintf("opcode %d", op)
+}
+
+func unknownOperator(op code.Opcode, left, right object.Object) error {
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operatorSymbol(op), right.Type())
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	operator, ok := binaryOperators[op]
+	if !ok {
+		return unknownOperator(op, left, right)
+	}
+
+	result, err := object.IntegerOperation(operator, left, right)
+	if err != nil {
+		return err
+	}
 
-	var result int64
+	return vm.push(result)
+}
+
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftValue, _ := object.ToFloat(left)
+	rightValue, _ := object.ToFloat(right)
+
+	var result float64
 
 	switch op {
 	case code.OpAdd:
@@ -315,16 +615,20 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 		result = leftValue * rightValue
 	case code.OpDiv:
 		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	case code.OpPow:
+		result = math.Pow(leftValue, rightValue)
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return unknownOperator(op, left, right)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(&object.Float{Value: result})
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+		return unknownOperator(op, left, right)
 	}
 
 	leftValue := left.(*object.String).Value
@@ -340,20 +644,51 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ && (op == code.OpEqual || op == code.OpNotEqual) {
+		// By value, as whether two equal strings are the same object depends on how their constants were pooled
+		equal := left.(*object.String).Value == right.(*object.String).Value
+		return vm.push(nativeBooltoBooleanObject(equal == (op == code.OpEqual)))
+	}
 
-	switch op {
-	case code.OpEqual:
+	switch {
+	case op == code.OpEqual:
 		return vm.push(nativeBooltoBooleanObject(left == right))
-	case code.OpNotEqual:
+	case op == code.OpNotEqual:
 		return vm.push(nativeBooltoBooleanObject(left != right))
+	case left.Type() != right.Type():
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operatorSymbol(op), right.Type())
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return unknownOperator(op, left, right)
 	}
 }
 
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	rightValue := right.(*object.Integer).Value
-	leftValue := left.(*object.Integer).Value
+	comparison := object.CompareIntegers(left, right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBooltoBooleanObject(comparison == 0))
+	case code.OpNotEqual:
+		return vm.push(nativeBooltoBooleanObject(comparison != 0))
+	case code.OpGreaterThan:
+		return vm.push(nativeBooltoBooleanObject(comparison > 0))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBooltoBooleanObject(comparison >= 0))
+	case code.OpLessThan:
+		return vm.push(nativeBooltoBooleanObject(comparison < 0))
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBooltoBooleanObject(comparison <= 0))
+	default:
+		return unknownOperator(op, left, right)
+	}
+}
+
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	leftValue, _ := object.ToFloat(left)
+	rightValue, _ := object.ToFloat(right)
 
 	switch op {
 	case code.OpEqual:
@@ -364,11 +699,27 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBooltoBooleanObject(leftValue > rightValue))
 	case code.OpGreaterThanOrEqual:
 		return vm.push(nativeBooltoBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBooltoBooleanObject(leftValue < rightValue))
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBooltoBooleanObject(leftValue <= rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return unknownOperator(op, left, right)
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	_, ok := object.ToFloat(obj)
+	return ok
+}
+
+func orNull(obj object.Object) object.Object {
+	if obj == nil {
+		return Null
+	}
+	return obj
+}
+
 func nativeBooltoBooleanObject(input bool) *object.Boolean {
 	if input {
 		return True
@@ -395,12 +746,25 @@ func (vm *VM) executeBangOperator() error {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
+	if f, ok := operand.(*object.Float); ok {
+		return vm.push(&object.Float{Value: -f.Value})
+	}
+
+	if operand.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
+	}
+
+	return vm.push(object.NegateInteger(operand))
+}
+
+func (vm *VM) executeBitNotOperator() error {
+	operand := vm.pop()
+
 	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+		return fmt.Errorf("unknown operator: ~%s", operand.Type())
 	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.push(object.BitNotInteger(operand))
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
@@ -409,21 +773,69 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 		return vm.executeArrayIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.EXCEPTION_OBJ:
+		return vm.push(orNull(left.(*object.Exception).Field(index)))
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
 }
 
+// Arrays and hashes are changed in place, so every reference to them sees the new element. The assigned value is
+// left on the stack as the result of the assignment
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		if index.Type() != object.INTEGER_OBJ {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		i, ok := index.(*object.Integer) // Not ok for a big integer, which is out of range anyway
+		if !ok || i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %s, length %d", index.Inspect(), len(left.Elements))
+		}
+		left.Elements[i.Value] = value
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
+// Combines the current element with the value using the binary operator op before storing it
+func (vm *VM) executeCompoundSetIndex(left, index, value object.Object, op code.Opcode) error {
+	err := vm.executeIndexExpression(left, index)
+	if err != nil {
+		return err
+	}
+
+	err = vm.push(value)
+	if err != nil {
+		return err
+	}
+
+	err = vm.executeBinaryOperation(op)
+	if err != nil {
+		return err
+	}
+
+	return vm.executeSetIndex(left, index, vm.pop())
+}
+
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObject := array.(*object.Array)
-	i := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
-
-	if i < 0 || i > max {
-		return vm.push(Null)
+	i, ok := index.(*object.Integer) // Not ok for a big integer, which is out of range anyway
+	if !ok || i.Value < 0 || i.Value >= int64(len(arrayObject.Elements)) {
+		return fmt.Errorf("index out of range: %s, length %d", index.Inspect(), len(arrayObject.Elements))
 	}
 
-	return vm.push(arrayObject.Elements[i])
+	return vm.push(arrayObject.Elements[i.Value])
 }
 
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
@@ -472,16 +884,26 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 }
 
 func (vm *VM) callFunction(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
 
-	fn, ok := vm.stack[vm.sp-1-numArgs].(*object.CompiledFunction)
-	if !ok {
-		return fmt.Errorf("calling non-function")
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
 	}
+}
 
-	if numArgs != fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumParameters, numArgs)
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
 	}
-	frame := NewFrame(fn, vm.sp-numArgs) // Get new frame from frame.go
+	if vm.framesIndex >= MaxFrames || vm.sp-numArgs+cl.Fn.NumLocals > StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	frame := NewFrame(cl, vm.sp-numArgs) // Get new frame from frame.go
 
 	/* Push frame on to VM frame stack frame.
 	Essentially, this modifies the currentFrame() that the VM is in, so after this happens,
@@ -489,7 +911,66 @@ func (vm *VM) callFunction(numArgs int) error {
 	When the frame is finally popped as part of the return statement executions, that is when the flow will return to the
 	original frame of the execution */
 	vm.pushFrame(frame)
-	vm.sp = frame.basePointer + fn.NumLocals // Creating a "hole" for local bindings by incrementing the stack pointer NumLocal times
+	vm.sp = frame.basePointer + cl.Fn.NumLocals // Creating a "hole" for local bindings by incrementing the stack pointer NumLocal times
+
+	// Clear whatever a previous call left in the hole, so that a binding read before it is set is null and not a stale
+	// value, and that assigning to it never writes into the cell of a variable an earlier call shared with a closure
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
 
 	return nil
 }
+
+// Assign to a local of the current frame, through its cell once a closure has captured it
+func (vm *VM) setLocal(index int, value object.Object) {
+	slot := &vm.stack[vm.currentFrame().basePointer+index]
+	if cell, ok := (*slot).(*object.Cell); ok {
+		cell.Value = value
+		return
+	}
+	*slot = value
+}
+
+// The value of a local slot or a free variable, which may be held in a cell
+func cellValue(obj object.Object) object.Object {
+	if cell, ok := obj.(*object.Cell); ok {
+		return cell.Value
+	}
+	return obj
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1 // Take the arguments and the builtin itself off the stack. No frame is needed since it is Go code
+
+	// A builtin reports misuse by returning an *object.Error, which the VM turns into a runtime error
+	if errObj, ok := result.(*object.Error); ok {
+		return fmt.Errorf("%s", errObj.Message)
+	}
+
+	if result != nil {
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	// The free variables were pushed right before OpClosure, so copy them off the stack and into the closure
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
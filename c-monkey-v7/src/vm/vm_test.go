@@ -2,11 +2,13 @@ package vm
 
 import (
 	"Compiler/c-monkey-v7/src/ast"
+	"Compiler/c-monkey-v7/src/code"
 	"Compiler/c-monkey-v7/src/compiler"
 	"Compiler/c-monkey-v7/src/lexer"
 	"Compiler/c-monkey-v7/src/object"
 	"Compiler/c-monkey-v7/src/parser"
 	"fmt"
+	"math/big"
 	"testing"
 )
 
@@ -15,6 +17,15 @@ type vmTestCase struct {
 	expected interface{}
 }
 
+// Expected value of a big integer result, in decimal
+func bigInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer " + s)
+	}
+	return n
+}
+
 func parse(input string) *ast.Program {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -44,6 +55,35 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 	}
 }
 
+// Like runVmTests, but every input has to fail at runtime with the expected message
+func runVmErrorTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.input)
+		}
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("VM error is not a RuntimeError. got=%T (%+v)", err, err)
+		}
+		if rtErr.Message != tt.expected {
+			t.Errorf("wrong VM error for %q: want=%q, got=%q", tt.input, tt.expected, rtErr.Message)
+		}
+	}
+}
+
 func testIntegerObject(expected int64, actual object.Object) error {
 	result, ok := actual.(*object.Integer)
 	if !ok {
@@ -57,6 +97,19 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -93,6 +146,22 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
 
+	case *big.Int:
+		result, ok := actual.(*object.BigInteger)
+		if !ok {
+			t.Errorf("object is not BigInteger. got=%T (%+v)", actual, actual)
+			return
+		}
+		if result.Value.Cmp(expected) != 0 {
+			t.Errorf("object has wrong value. got=%s, want=%s", result.Value, expected)
+		}
+
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
+
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -173,6 +242,157 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1}, // Truncated like /, so the sign follows the left operand
+		{"7 % -3", 1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"5 ** 0", 1},
+		{"2 ** 62", 4611686018427387904},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 << 63 >> 63", 1},
+		{"-1 >> 100", -1},
+		{"1 + 2 * 3 % 4 << 1 | 1", 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"2 ** 64", bigInt("18446744073709551616")},
+		{"18446744073709551616", bigInt("18446744073709551616")},
+		{"-18446744073709551616 + 1", bigInt("-18446744073709551615")},
+		{"-9223372036854775808", -9223372036854775808}, // The literal is big, its negation isn't
+		{"18446744073709551616 == 2 ** 64", true},
+		{"2 ** 100 / 2 ** 99", 2}, // Demoted once it fits again
+		{"(2 ** 64 + 5) % 2 ** 64", 5},
+		{"2 ** 64 - 2 ** 64", 0},
+		{"-(2 ** 63)", -9223372036854775808},
+		{"1 << 100 >> 99", 2},
+		{"~(2 ** 64)", bigInt("-18446744073709551617")},
+		{"2 ** 64 > 2 ** 63", true},
+		{"2 ** 64 == 1 << 64", true},
+		{"2 ** 64 != 2 ** 64 + 1", true},
+		{"-(2 ** 64) < 9223372036854775807", true},
+		{"2 ** 64 == 18446744073709551616.0", true},
+		{"2 ** 64 * 0.5", 9223372036854775808.0},
+		{`let h = {2 ** 64: "big"}; h[1 << 64]`, "big"},
+		{`let h = {}; h[2 ** 64] = 1; h[2.0 ** 64] += 1; h[2 ** 64]`, 2},
+		{"let x = 9223372036854775807; x += 1; x -= 1; x", 9223372036854775807},
+		{
+			`
+			let fib = fn(n) {
+				let a = 0;
+				let b = 1;
+				while (n > 0) {
+					let next = a + b;
+					a = b;
+					b = next;
+					n -= 1;
+				}
+				a
+			};
+			fib(100)
+			`,
+			bigInt("354224848179261915075"),
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"1e-3", 0.001},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"7 / 2", 3},
+		{"7 / 2.0", 3.5},
+		{"2.5 * 4", 10.0},
+		{"10 - 0.25", 9.75},
+		{"7.5 % 2", 1.5},
+		{"2 ** 0.5 * 2 ** 0.5 > 1.99", true},
+		{"2.0 ** 3", 8.0},
+		{"1 / 0.0 > 1e308", true}, // IEEE 754, so +Inf
+		{"let x = 1; x += 0.5; x", 1.5},
+		{"let a = [1.0]; a[0] *= 3; a[0]", 3.0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatComparisons(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5 < 2.5", true},
+		{"1.5 > 2.5", false},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"1 < 1.5", true},
+		{"2.0 >= 2", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{"-0.0 == 0.0", true},
+		{`let h = {1: "one", 2.5: "two and a half"}; h[1.0] + ", " + h[2.5]`, "one, two and a half"},
+		{"let h = {}; h[2.0] = 1; h[2] += 5; h[2.0]", 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIntegerArithmeticErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 % 0", "division by zero"},
+		{"2 ** -1", "negative exponent: -1"},
+		{"1 << -1", "negative shift count: -1"},
+		{"1 >> -3", "negative shift count: -3"},
+		{"~true", "unknown operator: ~BOOLEAN"},
+		{"~1.5", "unknown operator: ~FLOAT"},
+		{"1 / 0", "division by zero"},
+		{"2 ** 64 / 0", "division by zero"},
+		{"1 << 10000000000", "integer overflow: result does not fit in 1048576 bits"},
+		{"let a = [1]; a[2 ** 64] = 2", "index out of range: 18446744073709551616, length 1"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{"1 & true", "type mismatch: INTEGER & BOOLEAN"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+// Worded the same as the evaluator's errors, with the operator as it is written in the source
+func TestOperatorErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" + 1`, "type mismatch: STRING + INTEGER"},
+		{`"a" - "b"`, "unknown operator: STRING - STRING"},
+		{"true + false", "unknown operator: BOOLEAN + BOOLEAN"},
+		{`"a" < "b"`, "unknown operator: STRING < STRING"},
+		{`"a" <= 1`, "type mismatch: STRING <= INTEGER"},
+		{"[1] < 2", "type mismatch: ARRAY < INTEGER"},
+		{"true > false", "unknown operator: BOOLEAN > BOOLEAN"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{"1(2)", "not a function: INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+// < and <= evaluate their left operand first, like every other operator
+func TestComparisonOperandOrder(t *testing.T) {
+	tests := []vmTestCase{
+		{"let n = 0; let f = fn(x) { n = n * 10 + x; x }; f(1) < f(2); n", 12},
+		{"let n = 0; let f = fn(x) { n = n * 10 + x; x }; f(1) <= f(2); n", 12},
+		{"let n = 0; let f = fn(x) { n = n * 10 + x; x }; f(1) > f(2); n", 12},
 	}
 
 	runVmTests(t, tests)
@@ -215,6 +435,29 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestLogicalExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{"0 || 2", 0}, // 0 is truthy
+		{"if (false) { 1 } && 2", Null},
+		{"if (false) { 1 } || 2", 2},
+		{"1 < 2 && 2 < 3", true},
+		{"false && true || 5", 5},
+		{"if (1 > 2 || 3 > 2) { 10 } else { 20 }", 10},
+		{"let n = 0; let f = fn() { n += 1; true }; false && f(); true || f(); n", 0},
+		{"let n = 0; let f = fn() { n += 1; true }; true && f(); false || f(); n", 2},
+		{"let f = fn(a, b) { a && b }; f(true, 3)", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -227,11 +470,159 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 }", Null},
 		{"if (false) { 10 }", Null},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (true) { let a = 1; }", Null},
+		{"if (false) { 10 } else { }", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum = sum + x; }; sum", 6},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { sum = sum + i * x; }; sum", 80},
+		{"let n = 0; for (x in []) { n = n + 1; }; n", 0},
+		{`let s = ""; for (c in "abc") { s = c + s; }; s`, "cba"},
+		{`let s = ""; for (i, c in "abc") { if (i == 1) { continue; } s = s + c; }; s`, "ac"},
+		// Hashes are walked in key order, whatever order the literal had
+		{`let s = ""; for (k in {"b": 2, "c": 3, "a": 1}) { s = s + k; }; s`, "abc"},
+		{`let s = 0; for (k, v in {3: 30, 1: 10, 2: 20}) { s = s * 100 + k * v; }; s`, 104090},
+		{"let last = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } let last = x; }; last", 2},
+		{"let x = 99; for (x in [1, 2]) { }; x", 2},
+		// The names are one binding for the whole loop, so the closures made in the body all see the last element
+		{"let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }); }; fs[0]()", 2},
+		{"let f = fn() { let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }); }; fs[0]() }; f()", 2},
+		{`let f = fn() { let fs = []; for (k, v in {"a": 1, "b": 2}) { fs = push(fs, fn() { v }); }; fs[0]() }; f()`, 2},
+		{"let f = fn() { let fs = []; for (x in [1, 2]) { let y = x; fs = push(fs, fn() { y }); }; fs[0]() + fs[1]() }; f()", 4},
+		{
+			// Nested loops, break from the inner one, and a return from inside the outer one
+			`
+			let find = fn(rows, target) {
+				for (i, row in rows) {
+					for (x in row) {
+						if (x == 0) { break; }
+						if (x == target) { return i; }
+					}
+				}
+				-1
+			};
+			[find([[1, 2], [0, 3], [4, 3]], 3), find([[1]], 5)]
+			`,
+			[]int{2, -1},
+		},
+		{
+			// A break leaves nothing on the stack, even many times over
+			"let n = 0; while (n < 3000) { for (x in [1, 2]) { break; } n = n + 1; }; n",
+			3000,
+		},
+		{"let f = fn() { for (x in [1]) { } }; f()", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForLoopErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"for (x in 1) { }", "cannot iterate over INTEGER"},
+		{"let f = fn() { for (x in fn() {}) { } }; f()", "cannot iterate over CLOSURE"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestWhileLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 10) { i = i + 1; }; i", 10},
+		{"let i = 0; while (false) { i = i + 1; }; i", 0},
+		{"let i = 0; while (true) { i = i + 1; if (i == 5) { break; } }; i", 5},
+		{
+			// Sum of the odd numbers below 10
+			`
+			let i = 0;
+			let sum = 0;
+			while (i < 10) {
+				i = i + 1;
+				if (i / 2 * 2 == i) { continue; }
+				sum = sum + i;
+			};
+			sum
+			`,
+			25,
+		},
+		{
+			// Far more iterations than recursion could do with MaxFrames
+			"let i = 0; while (i < 100000) { i = i + 1; }; i",
+			100000,
+		},
+		{
+			`
+			let count = fn(n) {
+				let i = 0;
+				let pairs = 0;
+				while (i < n) {
+					let j = 0;
+					while (true) {
+						if (j == i) { break; }
+						pairs = pairs + 1;
+						j = j + 1;
+					}
+					i = i + 1;
+				}
+				pairs
+			};
+			count(5)
+			`,
+			10,
+		},
+		{"let i = 0; while (true) { let x = if (true) { break; }; i = 1; }; i", 0},
+		// A let in the body rebinds the variable, so it is updated on every pass and read after the loop
+		{"let i = 0; let n = 0; while (n < 5) { let i = i + 1; let n = n + 1; }; i", 5},
+		{"let f = fn() { let n = 0; while (n < 3) { let n = n + 1; }; n }; f()", 3},
+		{"let f = fn() { let total = 0; for (x in [1, 2, 3]) { let total = total + x; }; total }; f()", 6},
+		{"let f = fn() { while (false) { } }; f()", Null},
+		{"let f = fn() { let i = 0; while (true) { if (i == 3) { return i * 10; } i = i + 1; } }; f()", 30},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let a = 0; let b = 0; a = b = 3; a + b", 6},
+		{"let i = 0; let sum = 0; while (i < 5) { i += 1; sum += i; }; sum", 15},
+		{"let f = fn() { let n = 1; n *= 7; n }; f()", 7},
+		{"let total = 0; let add = fn(n) { total += n }; add(2); add(3); total", 5},
+		{"let f = fn() { f = 1 }; f(); f", 1},
+		{"let a = [1, 2, 3]; a[0] = 10; a", []int{10, 2, 3}},
+		{"let a = [1, 2, 3]; a[1] += 5", 7},
+		{"let a = [1, 2, 3]; let b = a; b[2] = 0; a", []int{1, 2, 0}},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] *= 7; h["a"] + h["b"]`, 9},
+		{`let h = {}; h[1] = "one"; h[true] = "yes"; h[1] + h[true]`, "oneyes"},
+		{"let m = [[1, 2], [3, 4]]; m[1][0] -= 1; m[1]", []int{2, 4}},
+		// A closure can't assign to what it captured, but it can change an array it shares
+		{"let f = fn() { let n = [0]; let inc = fn() { n[0] += 1 }; inc(); inc(); n[0] }; f()", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1]; a[1] = 2", "index out of range: 1, length 1"},
+		{"let a = [1]; a[-1] = 2", "index out of range: -1, length 1"},
+		{`let a = [1]; a["x"] = 2`, "array index must be INTEGER, got STRING"},
+		{"let h = {}; h[fn() {}] = 1", "unusable as hash key: CLOSURE"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+		{`let h = {}; h["a"] += 1`, "type mismatch: NULL + INTEGER"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestGlobalStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{"let one = 1; one", 1},
@@ -242,11 +633,65 @@ func TestGlobalStatements(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// The way the REPL runs lines, with the constant pool compacted after each one
+func TestCompactedConstantsBetweenRuns(t *testing.T) {
+	globals := make([]object.Object, GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	constants := []object.Object{}
+
+	lines := []string{`"garbage"; 1000`, `let f = fn(x) { x + 1 + 0.5 }`, `let n = 99; let g = fn() { f(n) }`, `[f(1), g()]`}
+	var result object.Object
+	for _, line := range lines {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(line)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.Bytecode()
+		vm := NewWithGlobalStore(bytecode, globals)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		result = vm.LastPoppedStackElem()
+
+		constants = compiler.CompactConstants(bytecode.Constants)
+	}
+
+	if result.Inspect() != "[2.5, 100.5]" {
+		t.Errorf("wrong result. want=[2.5, 100.5], got=%s", result.Inspect())
+	}
+	if len(constants) != 4 { // f and g, and the 1 and 0.5 that f uses
+		t.Errorf("wrong number of constants left. want=4, got=%d", len(constants))
+	}
+}
+
 func TestStringExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"say \"hi\"\n"`, "say \"hi\"\n"},
+		{"`raw \\n` + \"\\u00e9\"", "raw \\né"},
+		{`len("é")`, 2},
+		{`"a" == 1`, false},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Strings compare by value, whether or not they are the same object
+func TestStringEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{`let s = "a"; s + "b" == "ab"`, true},
+		{`let s = "a"; s + "b" != "ab"`, false},
+		{`let f = fn(x) { x + "!" }; f("hi") == f("hi")`, true},
+		{`let s = "a"; s == s + ""`, true},
+		{`"a" == 1`, false},
 	}
 
 	runVmTests(t, tests)
@@ -291,9 +736,6 @@ func TestIndexExpressions(t *testing.T) {
 		{"[1, 2, 3][1]", 2},
 		{"[1, 2, 3][0 + 2]", 3},
 		{"[[1, 1, 1]][0][0]", 1},
-		{"[][0]", Null},
-		{"[1, 2, 3][99]", Null},
-		{"[1][-1]", Null},
 		{"{1: 1, 2: 2}[1]", 1},
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
@@ -303,6 +745,43 @@ func TestIndexExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestCaughtValueTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.ObjectType
+	}{
+		{`let r = 0; try { 1 / 0 } catch (e) { r = e }; r`, object.EXCEPTION_OBJ},
+		{`let r = 0; try { throw {"message": "boom"} } catch (e) { r = e }; r`, object.HASH_OBJ},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		if got := vm.LastPoppedStackElem().Type(); got != tt.expected {
+			t.Errorf("caught value has wrong type. got=%s, want=%s", got, tt.expected)
+		}
+	}
+}
+
+func TestIndexErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"[][0]", "index out of range: 0, length 0"},
+		{"[1, 2, 3][99]", "index out of range: 99, length 3"},
+		{"[1][-1]", "index out of range: -1, length 1"},
+		{"[1, 2][2 ** 64]", "index out of range: 18446744073709551616, length 2"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestCallingFunctionWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -553,8 +1032,680 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 		if err == nil {
 			t.Fatalf("expected VM error but resulted in none.")
 		}
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("VM error is not a RuntimeError. got=%T (%+v)", err, err)
+		}
+		if rtErr.Message != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, rtErr.Message)
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let newClosure = fn(a) {
+				fn() { a; };
+			};
+			let closure = newClosure(99);
+			closure();
+			`,
+			expected: 99,
+		},
+		{
+			input: `
+			let newAdder = fn(a, b) {
+				fn(c) { a + b + c };
+			};
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			expected: 11,
+		},
+		{
+			input: `
+			let newAdder = fn(a, b) {
+				let c = a + b;
+				fn(d) { c + d };
+			};
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			expected: 11,
+		},
+		{
+			input: `
+			let newAdderOuter = fn(a, b) {
+				let c = a + b;
+				fn(d) {
+					let e = d + c;
+					fn(f) { e + f; };
+				};
+			};
+			let newAdderInner = newAdderOuter(1, 2);
+			let adder = newAdderInner(3);
+			adder(8);
+			`,
+			expected: 14,
+		},
+		{
+			input: `
+			let a = 1;
+			let newAdderOuter = fn(b) {
+				fn(c) {
+					fn(d) { a + b + c + d };
+				};
+			};
+			let newAdderInner = newAdderOuter(2);
+			let adder = newAdderInner(3);
+			adder(8);
+			`,
+			expected: 14,
+		},
+		{
+			input: `
+			let newClosure = fn(a, b) {
+				let one = fn() { a; };
+				let two = fn() { b; };
+				fn() { one() + two(); };
+			};
+			let closure = newClosure(9, 90);
+			closure();
+			`,
+			expected: 99,
+		},
+		{
+			input: `
+			let newAdder = fn(x) {
+				fn(y) { x + y; };
+			};
+			let addTwo = newAdder(2);
+			addTwo(2);
+			`,
+			expected: 4,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+// A closure shares the variables it captures with the function they belong to
+func TestCapturedVariables(t *testing.T) {
+	tests := []vmTestCase{
+		// Assigned by the function after the closure captured it
+		{"let f = fn() { let i = 0; let g = fn() { i }; i += 1; g() }; f()", 1},
+		{"let f = fn(x) { let g = fn() { x }; x = 5; g() }; f(1)", 5},
+		{"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()", 2},
+		// Assigned by the closure
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let f = fn() { let n = 0; let g = fn() { fn() { n = n + 10 } }; g()(); n }; f()", 10},
+		// Every call has variables of its own
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let a = counter(); let b = counter(); a(); a(); b()", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`puts("hello", "world!")`, Null},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, Null},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, Null},
+		{`push([], 1)`, []int{1}},
+		{`let double = fn(arr) { push(arr, len(arr) * 2) }; double([1, 2])`, []int{1, 2, 4}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctionErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{`len(1)`, "argument to len not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`first(1)`, "argument to first must be ARRAY, got INTEGER"},
+		{`last(1)`, "argument to last must be ARRAY, got INTEGER"},
+		{`push(1, 1)`, "argument to push must be ARRAY, got INTEGER"},
+		{`fn() { rest(1) }()`, "argument to rest must be ARRAY, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("VM error is not a RuntimeError. got=%T (%+v)", err, err)
+		}
+		if rtErr.Message != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, rtErr.Message)
+		}
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			let wrapper = fn() {
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						countDown(x - 1);
+					}
+				};
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					let step = fn() { countDown(x - 1) };
+					if (x == 0) { 0 } else { step() }
+				};
+				countDown(5);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let fibonacci = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					if (x == 1) {
+						return 1;
+					} else {
+						fibonacci(x - 1) + fibonacci(x - 2);
+					}
+				}
+			};
+			fibonacci(15);
+			`,
+			expected: 610,
+		},
+		{
+			input: `
+			let wrapper = fn(n) {
+				let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+				fib(n);
+			};
+			wrapper(15);
+			`,
+			expected: 610,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			isEven(10);
+			`,
+			expected: true,
+		},
+		{
+			input: `
+			let wrapper = fn(x) {
+				let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+				isOdd(x);
+			};
+			wrapper(7);
+			`,
+			expected: true,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let a = fn(n) { if (n == 0) { "a" } else { b(n - 1) } };
+				let b = fn(n) { if (n == 0) { "b" } else { c(n - 1) } };
+				let c = fn(n) { if (n == 0) { "c" } else { a(n - 1) } };
+				[a(4), b(4), c(4)];
+			};
+			wrapper()[2];
+			`,
+			expected: "a",
+		},
+		{
+			// Code before a let still sees the name as it was
+			input: `
+			let f = fn() { 1 };
+			let g = fn() { let x = f(); let f = fn() { 2 }; x * 10 + f() };
+			g();
+			`,
+			expected: 12,
+		},
+		{
+			input: `
+			let h = fn() { 1 };
+			let g = fn() { let fs = [fn() { h() }]; let h = fn() { 5 }; fs[0]() + h() };
+			g();
+			`,
+			expected: 6,
+		},
+		{
+			// A closure made by a function of the run shares the cell of the sibling bound after it
+			input: `
+			let outer = fn() {
+				let f = fn() { fn() { g() } };
+				let g = fn() { 7 };
+				f()();
+			};
+			outer();
+			`,
+			expected: 7,
+		},
+		{
+			// The second let rebinds the global that g reads
+			input: `
+			let f = 1;
+			let g = fn() { f };
+			let f = fn() { 2 };
+			g()() + f();
+			`,
+			expected: 4,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{`let r = 0; try { throw 5 } catch (e) { r = e }; r`, 5},
+		{`let r = 0; try { r = 1 } catch (e) { r = 2 }; r`, 1},
+		{`let r = ""; try { 1 / 0 } catch (e) { r = e["message"] }; r`, "division by zero"},
+		{`let r = ""; try { len(1) } catch (e) { r = e["message"] }; r`, "argument to len not supported, got INTEGER"},
+		{`let f = fn() { 1 + "a" }; let r = 0; try { f() } catch (e) { r = len(e["trace"]) }; r`, 2},
+		{`let f = fn() { first(1) }; let r = 0; try { f() } catch (e) { r = e["trace"][0] }; r`, "f (1:16)"},
+		{`let f = fn() { first(1) }; let r = 0; try { f() } catch (e) { r = e["trace"][1] }; r`, "<main> (1:45)"},
+		{`let r = ""; try { [1, 2][5] } catch (e) { r = e["message"] }; r`, "index out of range: 5, length 2"},
+		{`let r = 0; try { 1 / 0 } catch (e) { r = e["line"] }; r`, Null},
+		{`let f = fn() { f() }; let r = ""; try { f() } catch (e) { r = e["message"] }; r`, "stack overflow"},
+		{
+			// Unwinds every frame above the one with the handler
+			`let g = fn(n) { if (n == 0) { throw "deep" } else { g(n - 1) + 1 } };
+			let r = ""; try { g(10) } catch (e) { r = e }; r`,
+			"deep",
+		},
+		{
+			`let f = fn() { let r = 0; try { throw 1 } catch (e) { r = e + 1 }; r }; f() + f()`,
+			4,
+		},
+		{
+			// The stack is cut back to what was on it when the try started, not emptied
+			`let r = 1 + [2, if (true) { try { throw 1 } catch (e) { }; 3 }][1]; r`,
+			4,
+		},
+		{
+			`let n = 0; for (i in [1, 2, 3]) { try { if (i == 2) { throw i }; n += i } catch (e) { n += 10 * e } }; n`,
+			24,
+		},
+		{
+			`let r = ""; try { try { throw "a" } finally { r = r + "f" } } catch (e) { r = r + e }; r`,
+			"fa",
+		},
+		{
+			`let r = ""; try { try { throw "a" } catch (e) { throw e + "b" } } catch (e) { r = e }; r`,
+			"ab",
+		},
+		{
+			`let log = [];
+			let f = fn() { try { log = push(log, 1); return 2; } finally { log = push(log, 3) } };
+			let x = f();
+			log = push(log, x);
+			log`,
+			[]int{1, 3, 2},
+		},
+		{
+			`let log = [];
+			for (i in [1, 2, 3]) { try { if (i == 2) { continue; } if (i == 3) { break; } } finally { log = push(log, i) } }
+			log`,
+			[]int{1, 2, 3},
+		},
+		{
+			`let i = 0; let n = 0;
+			while (true) { try { i += 1; if (i > 3) { break; } } finally { n += 1 } }
+			n`,
+			4,
+		},
+		{
+			// A return in finally replaces the error
+			`let f = fn() { try { throw 1 } finally { return 2; } }; f()`,
+			2,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{`throw 1`, "uncaught exception: 1"},
+		{`throw "oops"`, "uncaught exception: oops"},
+		{`try { throw 1 } finally { 2 }`, "uncaught exception: 1"},
+		{`try { 1 / 0 } catch (e) { throw e }`, "division by zero"},
+		{`throw {"message": "boom"}`, "boom"},
+		{`let f = fn() { try { throw 1 } catch (e) { throw e + 1 } }; f()`, "uncaught exception: 2"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { f() }; f();", "stack overflow"},        // Runs out of frames first
+		{"let f = fn(n) { f(n + 1) }; f(0);", "stack overflow"}, // Runs out of stack first
+		{
+			"let f = fn(a, b, c, d, e, g, h, i) { let x = 1; let y = 2; f(a, b, c, d, e, g, h, i) }; f(1, 2, 3, 4, 5, 6, 7, 8);",
+			"stack overflow",
+		},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+func TestRunRecoversFromPanics(t *testing.T) {
+	// Hand-written bytecode that refers to a constant that doesn't exist, which the compiler would never emit
+	bytecode := &compiler.Bytecode{Instructions: code.Make(code.OpConstant, 5)}
+
+	vm := New(bytecode)
+	err := vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("VM error is not a RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	expected := "internal error: runtime error: index out of range [5] with length 0"
+	if rtErr.Message != expected {
+		t.Errorf("wrong VM error: want=%q, got=%q", expected, rtErr.Message)
+	}
+	if len(rtErr.Trace) != 1 || rtErr.Trace[0].Offset != 0 {
+		t.Errorf("wrong stack trace. got=%+v", rtErr.Trace)
+	}
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedLine    int
+		expectedCol     int
+	}{
+		{"1 + \"a\"", "type mismatch: INTEGER + STRING", 1, 3},
+		{"let x = 1;\nlet y = x;\n-true", "unknown operator: -BOOLEAN", 3, 1},
+		{"let f = fn(a) {\n  a * [1]\n};\nf(2)", "type mismatch: INTEGER * ARRAY", 2, 5},
+		{"let f = fn(a) { a };\n\n  f(1, 2)", "wrong number of arguments: want=1, got=2", 3, 3},
+		{"[1, 2][\"a\"]", "index operator not supported: ARRAY", 1, 7},
+		{"if (true) {\n  first(5)\n}", "argument to first must be ARRAY, got INTEGER", 2, 3},
+		{"let x = 10;\n\nx / (x - 10)", "division by zero", 3, 3},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.input)
+		}
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("VM error is not a RuntimeError. got=%T (%+v)", err, err)
+		}
+
+		if rtErr.Message != tt.expectedMessage {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expectedMessage, rtErr.Message)
+		}
+
+		if rtErr.Pos.Line != tt.expectedLine || rtErr.Pos.Column != tt.expectedCol {
+			t.Errorf("wrong position for %q. want=%d:%d, got=%d:%d", tt.expectedMessage, tt.expectedLine, tt.expectedCol, rtErr.Pos.Line, rtErr.Pos.Column)
+		}
+	}
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+let wrapper = fn() {
+  let inner = fn() { add(1, "two") };
+  inner()
+};
+wrapper();`
+
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("VM error is not a RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	expected := []struct {
+		function string
+		line     int
+		col      int
+	}{
+		{"add", 2, 5},
+		{"inner", 5, 22},
+		{"wrapper", 6, 3},
+		{"<main>", 8, 1},
+	}
+
+	if len(rtErr.Trace) != len(expected) {
+		t.Fatalf("wrong number of stack frames. want=%d, got=%d (%+v)", len(expected), len(rtErr.Trace), rtErr.Trace)
+	}
+
+	for i, tt := range expected {
+		frame := rtErr.Trace[i]
+		if frame.Function != tt.function {
+			t.Errorf("frame %d - wrong function. want=%q, got=%q", i, tt.function, frame.Function)
+		}
+		if frame.Pos.Line != tt.line || frame.Pos.Column != tt.col {
+			t.Errorf("frame %d - wrong position. want=%d:%d, got=%d:%d", i, tt.line, tt.col, frame.Pos.Line, frame.Pos.Column)
+		}
+	}
+
+	expectedTrace := `runtime error: type mismatch: INTEGER + STRING
+	at add (2:5, offset 4)
+	at inner (5:22, offset 9)
+	at wrapper (6:3, offset 6)
+	at <main> (8:1, offset 17)
+`
+	if rtErr.StackTrace() != expectedTrace {
+		t.Errorf("wrong stack trace.\nwant=%q\ngot=%q", expectedTrace, rtErr.StackTrace())
+	}
+}
+
+func TestRuntimeErrorStackTraceAnonymous(t *testing.T) {
+	program := parse(`fn() { -"a" }()`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("VM error is not a RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	if len(rtErr.Trace) != 2 || rtErr.Trace[0].Function != "<anonymous>" || rtErr.Trace[1].Function != "<main>" {
+		t.Errorf("wrong stack trace. got=%+v", rtErr.Trace)
+	}
+}
+
+// Programs spending their time in the bytecode the peephole optimizer cleans up, with what they evaluate to
+var peepholeBenchmarks = []struct {
+	name     string
+	input    string
+	expected int
+}{
+	{
+		// The nested if jumps to the end of the outer one through its OpJump
+		"classify",
+		`let classify = fn(n) {
+			let i = 0; let total = 0;
+			while (i < n) { if (i % 2 == 0) { if (i % 3 == 0) { total += 1 } else { total += 2 } } else { total += 3 } i += 1; }
+			total
+		}; classify(10000)`,
+		23333,
+	},
+	{
+		// Assignment statements on locals
+		"sum",
+		`let sum = fn(n) { let i = 0; let total = 0; while (i < n) { total = total + i; i = i + 1; } total }; sum(10000)`,
+		49995000,
+	},
+	{
+		// while (true) and the jump chains out of it
+		"count",
+		`let count = fn(n) { let i = 0; while (true) { if (i == n) { break; } else { i += 1; } } i }; count(10000)`,
+		10000,
+	},
+}
+
+// Without peephole, the program is still folded and interned, so that the two differ only by the peephole pass
+func compileForBenchmark(tb testing.TB, input string, peephole bool) *compiler.Bytecode {
+	tb.Helper()
+
+	comp := compiler.New()
+	if !peephole {
+		comp.DisablePeephole()
+	}
+	err := comp.Compile(parse(input))
+	if err != nil {
+		tb.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func TestPeepholeBenchmarkPrograms(t *testing.T) {
+	for _, tt := range peepholeBenchmarks {
+		for _, peephole := range []bool{false, true} {
+			vm := New(compileForBenchmark(t, tt.input, peephole))
+			err := vm.Run()
+			if err != nil {
+				t.Fatalf("%s: vm error: %s", tt.name, err)
+			}
+			if err := testIntegerObject(int64(tt.expected), vm.LastPoppedStackElem()); err != nil {
+				t.Errorf("%s (peephole=%t): %s", tt.name, peephole, err)
+			}
+		}
+	}
+}
+
+// Compare the two with go test -bench Peephole ./vm
+func BenchmarkPeephole(b *testing.B) {
+	for _, bm := range peepholeBenchmarks {
+		for _, peephole := range []bool{false, true} {
+			name := bm.name + "/without-peephole"
+			if peephole {
+				name = bm.name + "/peephole"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				bytecode := compileForBenchmark(b, bm.input, peephole)
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					err := New(bytecode).Run()
+					if err != nil {
+						b.Fatalf("vm error: %s", err)
+					}
+				}
+			})
 		}
 	}
 }